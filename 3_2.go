@@ -0,0 +1,210 @@
+package h2spec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/http2"
+)
+
+func StartingHttp2ForHttpUrisTestGroup(ctx *Context) *TestGroup {
+	if !ctx.Upgrade || ctx.Tls {
+		return nil
+	}
+
+	tg := NewTestGroup("3.2", "Starting HTTP/2 for \"http\" URIs")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an upgrade request",
+		"The endpoint MUST switch to HTTP/2 and send the response to the request on stream 1.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultHttp1Response{"101 Switching Protocols"},
+				&ResultStreamClose{},
+			}
+
+			conn, err := connect(ctx)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer conn.Close()
+
+			writeUpgradeRequest(conn, upgradeRequestHeaders(ctx))
+
+			br := bufio.NewReader(conn)
+			res, err := readUpgradeResponse(ctx, conn, br)
+			if err != nil {
+				return false, expected, upgradeResponseError(err)
+			}
+			res.Body.Close()
+
+			if !isUpgraded(res) {
+				return false, expected, &ResultHttp1Response{res.Status}
+			}
+
+			http2Conn := upgradedHttp2Conn(ctx, conn, br)
+
+			pass, _, actual = TestStreamClose(ctx, http2Conn)
+			return pass, expected, actual
+		},
+	))
+
+	tg.AddTestGroup(Http2SettingsHeaderFieldTestGroup(ctx))
+
+	return tg
+}
+
+func Http2SettingsHeaderFieldTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("3.2.1", "HTTP2-Settings Header Field")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an upgrade request without HTTP2-Settings header field",
+		"The endpoint MUST NOT upgrade the connection to HTTP/2.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := []string{
+				"Host: " + commonHeaderFieldAuthority(ctx).Value,
+				"Connection: Upgrade",
+				"Upgrade: h2c",
+			}
+
+			return TestUpgradeRefused(ctx, hdrs, nil)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an upgrade request with multiple HTTP2-Settings header fields",
+		"The endpoint MUST NOT upgrade the connection to HTTP/2.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := upgradeRequestHeaders(ctx)
+			hdrs = append(hdrs, "HTTP2-Settings: ")
+
+			return TestUpgradeRefused(ctx, hdrs, nil)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an upgrade request with HTTP2-Settings header field that is not base64url encoded",
+		"The endpoint MUST NOT upgrade the connection to HTTP/2, or MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := upgradeRequestHeaders(ctx)
+			hdrs[3] = "HTTP2-Settings: !!invalid/settings!!"
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestUpgradeRefused(ctx, hdrs, actualCodes)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an upgrade request with HTTP2-Settings header field whose payload length is not a multiple of 6 octets",
+		"The endpoint MUST NOT upgrade the connection to HTTP/2, or MUST respond with a connection error of type FRAME_SIZE_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := upgradeRequestHeaders(ctx)
+			// SETTINGS_MAX_CONCURRENT_STREAMS without the last octet
+			hdrs[3] = "HTTP2-Settings: AAMAAAA"
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeFrameSize, http2.ErrCodeProtocol}
+			return TestUpgradeRefused(ctx, hdrs, actualCodes)
+		},
+	))
+
+	if ctx.Strict {
+		tg.AddTestCase(NewTestCase(
+			"Sends an upgrade request without HTTP2-Settings connection option",
+			"The endpoint should not upgrade the connection to HTTP/2.",
+			func(ctx *Context) (pass bool, expected []Result, actual Result) {
+				hdrs := upgradeRequestHeaders(ctx)
+				hdrs[1] = "Connection: Upgrade"
+
+				return TestUpgradeRefused(ctx, hdrs, nil)
+			},
+		))
+	}
+
+	if ctx.Strict {
+		tg.AddTestCase(NewTestCase(
+			"Sends an upgrade request without Upgrade connection option",
+			"The endpoint should not upgrade the connection to HTTP/2.",
+			func(ctx *Context) (pass bool, expected []Result, actual Result) {
+				hdrs := upgradeRequestHeaders(ctx)
+				hdrs[1] = "Connection: HTTP2-Settings"
+
+				return TestUpgradeRefused(ctx, hdrs, nil)
+			},
+		))
+	}
+
+	return tg
+}
+
+// TestUpgradeRefused sends an upgrade request with the header lines
+// hdrs and passes if the server does not switch to HTTP/2.  A server
+// which switches and then terminates the connection with one of codes
+// passes too.
+func TestUpgradeRefused(ctx *Context, hdrs []string, codes []http2.ErrCode) (pass bool, expected []Result, actual Result) {
+	expected = []Result{&ResultNoUpgrade{}}
+	for _, code := range codes {
+		expected = append(expected, &ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, code})
+	}
+	expected = append(expected, &ResultConnectionClose{})
+
+	conn, err := connect(ctx)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer conn.Close()
+
+	writeUpgradeRequest(conn, hdrs)
+
+	br := bufio.NewReader(conn)
+	res, err := readUpgradeResponse(ctx, conn, br)
+	if err != nil {
+		actual = upgradeResponseError(err)
+		_, closed := actual.(*ResultConnectionClose)
+		return closed, expected, actual
+	}
+	res.Body.Close()
+
+	actual = &ResultHttp1Response{res.Status}
+	if !isUpgraded(res) {
+		return true, expected, actual
+	}
+	if len(codes) == 0 {
+		return false, expected, actual
+	}
+
+	http2Conn := upgradedHttp2Conn(ctx, conn, br)
+
+	pass, _, actual = TestConnectionError(ctx, http2Conn, codes)
+	return pass, expected, actual
+}
+
+// upgradedHttp2Conn starts HTTP/2 on conn, which the server switched
+// to h2c, and sends the SETTINGS frame.  br holds the bytes read after
+// the response to the upgrade request.  The connection is registered
+// with the running test case like the ones of CreateHttp2Conn.
+func upgradedHttp2Conn(ctx *Context, conn net.Conn, br *bufio.Reader) *Http2Conn {
+	fmt.Fprintf(conn, clientPreface)
+	http2Conn := newHttp2Conn(&bufferedConn{conn, br}, map[http2.SettingID]uint32{})
+	http2Conn.upgraded()
+	if ctx.testCase != nil {
+		ctx.testCase.addConn(http2Conn)
+	}
+	http2Conn.fr.WriteSettings()
+
+	return http2Conn
+}
+
+// upgradeResponseError converts an error occurred while reading the
+// response to an upgrade request into a Result.
+func upgradeResponseError(err error) Result {
+	if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, syscall.ECONNRESET) {
+		return &ResultConnectionClose{}
+	} else if err == TIMEOUT {
+		return &ResultTestTimeout{}
+	}
+	return &ResultError{err}
+}
@@ -0,0 +1,116 @@
+package h2spec
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+func StartingHttp2ForHttpsUrisTestGroup(ctx *Context) *TestGroup {
+	if !ctx.Tls {
+		return nil
+	}
+
+	tg := NewTestGroup("3.3", "Starting HTTP/2 for \"https\" URIs")
+
+	tg.AddTestCase(NewTestCase(
+		"Offers the protocols of --alpn with ALPN",
+		"The endpoint MUST select \"h2\" to use HTTP/2 over TLS.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultNegotiatedProtocol{"h2"},
+			}
+
+			return testAlpn(ctx, nil, expected, func(protocol string) bool {
+				return protocol == "h2"
+			})
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Offers \"h2\" and \"http/1.1\" with ALPN in this order",
+		"The endpoint is expected to select \"h2\".",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultNegotiatedProtocol{"h2"},
+			}
+
+			return testAlpn(ctx, []string{"h2", "http/1.1"}, expected, func(protocol string) bool {
+				return protocol == "h2"
+			})
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Offers \"http/1.1\" and \"h2\" with ALPN in this order",
+		"The endpoint is expected to select \"h2\" regardless of the order of the client's preference.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultNegotiatedProtocol{"h2"},
+			}
+
+			return testAlpn(ctx, []string{"http/1.1", "h2"}, expected, func(protocol string) bool {
+				return protocol == "h2"
+			})
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Offers only \"http/1.1\" with ALPN",
+		"The endpoint MUST NOT select \"h2\", which was not offered.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultNegotiatedProtocol{"http/1.1"},
+				&ResultHandshakeFailure{},
+			}
+
+			return testAlpn(ctx, []string{"http/1.1"}, expected, func(protocol string) bool {
+				return !isHttp2Protocol(protocol)
+			})
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Offers only the draft identifiers \"h2-14\" and \"h2-16\" with ALPN",
+		"The endpoint is expected not to select a draft of HTTP/2.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultNegotiatedProtocol{},
+				&ResultHandshakeFailure{},
+			}
+
+			return testAlpn(ctx, []string{"h2-14", "h2-16"}, expected, func(protocol string) bool {
+				return !strings.HasPrefix(protocol, "h2-")
+			})
+		},
+	))
+
+	return tg
+}
+
+// testAlpn performs a TLS handshake offering protocols with ALPN, or the
+// protocols of ctx.Alpn if nil, and passes if accept returns true for
+// the protocol selected by the endpoint.  A handshake refused by the
+// endpoint with a TLS alert is treated as no protocol selected.
+func testAlpn(ctx *Context, protocols []string, expected []Result, accept func(protocol string) bool) (pass bool, _ []Result, actual Result) {
+	conn, err := handshakeTls(ctx, func(config *tls.Config) {
+		if protocols != nil {
+			config.NextProtos = protocols
+		}
+	})
+	if err != nil {
+		if !isTlsAlert(err) {
+			return false, expected, &ResultError{err}
+		}
+		return accept(""), expected, &ResultHandshakeFailure{err}
+	}
+	defer conn.Close()
+
+	// the protocols offered by the other test cases are not the ones
+	// the user asked for.
+	if protocols == nil && ctx.report != nil {
+		ctx.report.Target.recordConnState(conn.ConnectionState())
+	}
+
+	protocol := conn.ConnectionState().NegotiatedProtocol
+	return accept(protocol), expected, &ResultNegotiatedProtocol{protocol}
+}
@@ -1,11 +1,15 @@
 package h2spec
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
-	"syscall"
+	"net/http"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 func Http2ConnectionPrefaceTestGroup(ctx *Context) *TestGroup {
@@ -15,40 +19,49 @@ func Http2ConnectionPrefaceTestGroup(ctx *Context) *TestGroup {
 		"Sends invalid connection preface",
 		"The endpoint MUST terminate the TCP connection.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultConnectionClose{},
+			conn, err := connect(ctx)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
+			defer conn.Close()
+
+			fmt.Fprintf(conn, "INVALID CONNECTION PREFACE\r\n\r\n")
 
-			tcpConn := CreateTcpConn(ctx)
-			defer tcpConn.conn.Close()
-
-			fmt.Fprintf(tcpConn.conn, "INVALID CONNECTION PREFACE\r\n\r\n")
-			timeCh := time.After(ctx.Timeout)
-
-		loop:
-			for {
-				select {
-				case <-tcpConn.dataCh:
-					break
-				case err := <-tcpConn.errCh:
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						pass = true
-						actual = &ResultConnectionClose{}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				case <-timeCh:
-					actual = &ResultTestTimeout{}
-					break loop
-				}
+			br := bufio.NewReader(conn)
+			skipHttp1Response(ctx, conn, br)
+
+			http2Conn := newHttp2Conn(&bufferedConn{conn, br}, map[http2.SettingID]uint32{})
+			if ctx.testCase != nil {
+				ctx.testCase.addConn(http2Conn)
 			}
 
-			return pass, expected, actual
+			return http2Conn.Expect(ctx,
+				ExpectGoAway(http2.ErrCodeProtocol),
+				ExpectConnectionClose(),
+			)
 		},
 	))
 
 	return tg
 }
+
+// skipHttp1Response reads the HTTP/1.1 response which an h2c endpoint
+// sends if it takes the invalid connection preface for a request, so
+// that only the connection close following it is left to be read.
+func skipHttp1Response(ctx *Context, conn net.Conn, br *bufio.Reader) {
+	conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// a read error is left to the next read of the connection.
+	head, err := br.Peek(5)
+	if err != nil || string(head) != "HTTP/" {
+		return
+	}
+
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
@@ -11,9 +11,14 @@ func FrameSizeTestGroup(ctx *Context) *TestGroup {
 		"Sends large size frame that exceeds the SETTINGS_MAX_FRAME_SIZE",
 		"The endpoint MUST send a FRAME_SIZE_ERROR error.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			// Set INITIAL_WINDOW_SIZE to zero to prevent the peer from closing the stream
 			settings := http2.Setting{http2.SettingInitialWindowSize, 0}
 			http2Conn.fr.WriteSettings(settings)
@@ -21,7 +26,7 @@ func FrameSizeTestGroup(ctx *Context) *TestGroup {
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -31,7 +36,7 @@ func FrameSizeTestGroup(ctx *Context) *TestGroup {
 				max_size = 18384
 			}
 
-			http2Conn.fr.WriteData(1, true, []byte(dummyData(int(max_size)+1)))
+			http2Conn.fr.WriteData(streamID, true, []byte(dummyData(int(max_size)+1)))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeFrameSize}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -1,7 +1,6 @@
 package h2spec
 
 import (
-	"fmt"
 	"golang.org/x/net/http2"
 )
 
@@ -12,11 +11,16 @@ func HeaderCompressionAndDecompressionTestGroup(ctx *Context) *TestGroup {
 		"Sends invalid header block fragment",
 		"The endpoint MUST terminate the connection with a connection error of type COMPRESSION_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			// Literal Header Field with Incremental Indexing without Length and String segment
-			fmt.Fprintf(http2Conn.conn, "\x00\x00\x01\x01\x05\x00\x00\x00\x01\x40")
+			http2Conn.fr.WriteRawFrame(http2.FrameHeaders, 0x05, streamID, []byte("\x40"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeCompression}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -27,16 +31,21 @@ func HeaderCompressionAndDecompressionTestGroup(ctx *Context) *TestGroup {
 		"Sends Dynamic Table Size Update (RFC 7541, 6.3)",
 		"The endpoint must accept Dynamic Table Size Update",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 
 			// 2 Dynamic Table Size Updates, 0 and 4096.
 			blockFragment := []byte{0x20, 0x3f, 0xe1, 0x1f}
 			blockFragment = append(blockFragment, http2Conn.EncodeHeader(hdrs)...)
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = blockFragment
@@ -50,9 +59,14 @@ func HeaderCompressionAndDecompressionTestGroup(ctx *Context) *TestGroup {
 		"Encodes Dynamic Table Size Update (RFC 7541, 6.3) after common header fields",
 		"The endpoint MUST terminate the connection with a connection error of type COMPRESSION_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 
 			blockFragment := http2Conn.EncodeHeader(hdrs)
@@ -65,7 +79,7 @@ func HeaderCompressionAndDecompressionTestGroup(ctx *Context) *TestGroup {
 			blockFragment = append(blockFragment, 0x20, 0x3f, 0xe1, 0x1f)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = blockFragment
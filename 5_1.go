@@ -11,10 +11,15 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"idle: Sends a DATA frame",
 		"The endpoint MUST treat this as a connection error (Section 5.4.1) of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
-			http2Conn.fr.WriteData(1, true, []byte("test"))
+			streamID := http2Conn.NextStreamID()
+
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -25,10 +30,15 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"idle: Sends a RST_STREAM frame",
 		"The endpoint MUST treat this as a connection error (Section 5.4.1) of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
-			http2Conn.fr.WriteRSTStream(1, http2.ErrCodeCancel)
+			streamID := http2Conn.NextStreamID()
+
+			http2Conn.fr.WriteRSTStream(streamID, http2.ErrCodeCancel)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -39,10 +49,15 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"idle: Sends a WINDOW_UPDATE frame",
 		"The endpoint MUST treat this as a connection error (Section 5.4.1) of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
-			http2Conn.fr.WriteWindowUpdate(1, 100)
+			streamID := http2Conn.NextStreamID()
+
+			http2Conn.fr.WriteWindowUpdate(streamID, 100)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -53,13 +68,18 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"idle: Sends a CONTINUATION frame",
 		"The endpoint MUST treat this as a connection error (Section 5.4.1) of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
-			http2Conn.fr.WriteContinuation(1, true, blockFragment)
+			http2Conn.fr.WriteContinuation(streamID, true, blockFragment)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -70,20 +90,25 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"half closed (remote): Sends a DATA frame",
 		"The endpoint MUST respond with a stream error (Section 5.4.2) of type STREAM_CLOSED.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = blockFragment
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteData(1, true, []byte("test"))
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeStreamClosed}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -94,21 +119,26 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"half closed (remote): Sends a HEADERS frame",
 		"The endpoint MUST respond with a stream error (Section 5.4.2) of type STREAM_CLOSED.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp1 http2.HeadersFrameParam
-			hp1.StreamID = 1
+			hp1.StreamID = streamID
 			hp1.EndStream = true
 			hp1.EndHeaders = true
 			hp1.BlockFragment = blockFragment
 			http2Conn.fr.WriteHeaders(hp1)
 
 			var hp2 http2.HeadersFrameParam
-			hp2.StreamID = 1
+			hp2.StreamID = streamID
 			hp2.EndStream = true
 			hp2.EndHeaders = true
 			hp2.BlockFragment = blockFragment
@@ -123,20 +153,25 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"half closed (remote): Sends a CONTINUATION frame",
 		"The endpoint MUST respond with a stream error (Section 5.4.2) of type STREAM_CLOSED.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = blockFragment
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, true, blockFragment)
+			http2Conn.fr.WriteContinuation(streamID, true, blockFragment)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeStreamClosed, http2.ErrCodeProtocol}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -148,14 +183,19 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 			"closed: Sends a DATA frame",
 			"The endpoint MUST treat this as a stream error (Section 5.4.2) of type STREAM_CLOSED.",
 			func(ctx *Context) (pass bool, expected []Result, actual Result) {
-				http2Conn := CreateHttp2Conn(ctx, true)
+				http2Conn, err := CreateHttp2Conn(ctx, true)
+				if err != nil {
+					return false, expected, &ResultError{err}
+				}
 				defer http2Conn.conn.Close()
 
+				streamID := http2Conn.NextStreamID()
+
 				hdrs := commonHeaderFields(ctx)
 				blockFragment := http2Conn.EncodeHeader(hdrs)
 
 				var hp http2.HeadersFrameParam
-				hp.StreamID = 1
+				hp.StreamID = streamID
 				hp.EndStream = true
 				hp.EndHeaders = true
 				hp.BlockFragment = blockFragment
@@ -166,7 +206,7 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 					return pass, expected, actual
 				}
 
-				http2Conn.fr.WriteData(1, true, []byte("test"))
+				http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 				actualCodes := []http2.ErrCode{http2.ErrCodeStreamClosed}
 				return TestStreamError(ctx, http2Conn, actualCodes)
@@ -179,14 +219,19 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 			"closed: Sends a HEADERS frame",
 			"The endpoint MUST treat this as a stream error (Section 5.4.2) of type STREAM_CLOSED.",
 			func(ctx *Context) (pass bool, expected []Result, actual Result) {
-				http2Conn := CreateHttp2Conn(ctx, true)
+				http2Conn, err := CreateHttp2Conn(ctx, true)
+				if err != nil {
+					return false, expected, &ResultError{err}
+				}
 				defer http2Conn.conn.Close()
 
+				streamID := http2Conn.NextStreamID()
+
 				hdrs := commonHeaderFields(ctx)
 				blockFragment := http2Conn.EncodeHeader(hdrs)
 
 				var hp http2.HeadersFrameParam
-				hp.StreamID = 1
+				hp.StreamID = streamID
 				hp.EndStream = true
 				hp.EndHeaders = true
 				hp.BlockFragment = blockFragment
@@ -209,9 +254,14 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 		"closed: Sends a CONTINUATION frame",
 		"The endpoint MUST treat this as a stream error (Section 5.4.2) of type STREAM_CLOSED.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -221,20 +271,20 @@ func StreamStatesTestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, true, blockFragment[16384:])
+			http2Conn.fr.WriteContinuation(streamID, true, blockFragment[16384:])
 
 			pass, expected, actual = TestStreamClose(ctx, http2Conn)
 			if !pass {
 				return pass, expected, actual
 			}
 
-			http2Conn.fr.WriteContinuation(1, true, blockFragment[16384:])
+			http2Conn.fr.WriteContinuation(streamID, true, blockFragment[16384:])
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeStreamClosed, http2.ErrCodeProtocol}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -254,7 +304,10 @@ func StreamIdentifiersTestGroup(ctx *Context) *TestGroup {
 		"Sends even-numbered stream identifier",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			hdrs := commonHeaderFields(ctx)
@@ -276,20 +329,25 @@ func StreamIdentifiersTestGroup(ctx *Context) *TestGroup {
 			"Sends stream identifier that is numerically smaller than previous",
 			"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 			func(ctx *Context) (pass bool, expected []Result, actual Result) {
-				http2Conn := CreateHttp2Conn(ctx, true)
+				http2Conn, err := CreateHttp2Conn(ctx, true)
+				if err != nil {
+					return false, expected, &ResultError{err}
+				}
 				defer http2Conn.conn.Close()
 
+				streamID := http2Conn.NextStreamID()
+
 				hdrs := commonHeaderFields(ctx)
 
 				var hp1 http2.HeadersFrameParam
-				hp1.StreamID = 5
+				hp1.StreamID = streamID + 4
 				hp1.EndStream = true
 				hp1.EndHeaders = true
 				hp1.BlockFragment = http2Conn.EncodeHeader(hdrs)
 				http2Conn.fr.WriteHeaders(hp1)
 
 				var hp2 http2.HeadersFrameParam
-				hp2.StreamID = 3
+				hp2.StreamID = streamID + 2
 				hp2.EndStream = true
 				hp2.EndHeaders = true
 				hp2.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -311,7 +369,10 @@ func StreamConcurrencyTestGroup(ctx *Context) *TestGroup {
 		"Sends HEADERS frames that causes their advertised concurrent stream limit to be exceeded",
 		"The endpoint MUST treat this as a stream error (Section 5.4.2) of type PROTOCOL_ERROR or REFUSED_STREAM",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			// Skip this test when SETTINGS_MAX_CONCURRENT_STREAMS is unlimited.
@@ -328,7 +389,7 @@ func StreamConcurrencyTestGroup(ctx *Context) *TestGroup {
 			hdrs := commonHeaderFields(ctx)
 			hbf := http2Conn.EncodeHeader(hdrs)
 
-			var streamID uint32 = 1
+			streamID := http2Conn.NextStreamID()
 			for i := 0; i <= int(http2Conn.Settings[http2.SettingMaxConcurrentStreams]); i++ {
 				var hp http2.HeadersFrameParam
 				hp.StreamID = streamID
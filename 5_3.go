@@ -19,18 +19,23 @@ func StreamDependenciesTestGroup(ctx *Context) *TestGroup {
 		"Sends HEADERS frame that depend on itself",
 		"The endpoint MUST treat this as a stream error of type PROTOCOL_ERROR",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 
 			var pp http2.PriorityParam
-			pp.StreamDep = 3
+			pp.StreamDep = streamID + 2
 			pp.Exclusive = false
 			pp.Weight = 255
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 3
+			hp.StreamID = streamID + 2
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.Priority = pp
@@ -46,7 +51,10 @@ func StreamDependenciesTestGroup(ctx *Context) *TestGroup {
 		"Sends PRIORITY frame that depend on itself",
 		"The endpoint MUST treat this as a stream error of type PROTOCOL_ERROR",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			var pp http2.PriorityParam
@@ -2,9 +2,6 @@ package h2spec
 
 import (
 	"golang.org/x/net/http2"
-	"io"
-	"net"
-	"syscall"
 )
 
 func ExtendingHttp2TestGroup(ctx *Context) *TestGroup {
@@ -14,12 +11,10 @@ func ExtendingHttp2TestGroup(ctx *Context) *TestGroup {
 		"Sends an unknown extension frame",
 		"The endpoint MUST discard frames that have unknown or unsupported types",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FramePing, http2.FlagPingAck, ErrCodeDefault},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
 			// Write a frame of type 0xFF, which isn't yet defined
@@ -33,42 +28,7 @@ func ExtendingHttp2TestGroup(ctx *Context) *TestGroup {
 			data := [8]byte{'h', '2', 's', 'p', 'e', 'c'}
 			http2Conn.fr.WritePing(false, data)
 
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-
-				switch f := f.(type) {
-				case *http2.PingFrame:
-					actual = CreateResultFrame(f)
-					if f.FrameHeader.Flags.Has(http2.FlagPingAck) {
-						pass = true
-						break loop
-					}
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				default:
-					actual = CreateResultFrame(f)
-				}
-			}
-
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, ExpectPingAck(data))
 		},
 	))
 
@@ -76,9 +36,14 @@ func ExtendingHttp2TestGroup(ctx *Context) *TestGroup {
 		"Sends an unknown extension frame in the middle of a header block",
 		"The endpoint MUST treat as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -88,7 +53,7 @@ func ExtendingHttp2TestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
@@ -2,9 +2,6 @@ package h2spec
 
 import (
 	"golang.org/x/net/http2"
-	"io"
-	"net"
-	"syscall"
 )
 
 func ContinuationTestGroup(ctx *Context) *TestGroup {
@@ -14,14 +11,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 		"Sends a CONTINUATION frame",
 		"The endpoint must accept the frame.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameHeaders, FlagDefault, ErrCodeDefault},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -31,43 +28,15 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, true, blockFragment[16384:])
-
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-
-				actual = CreateResultFrame(f)
-				_, ok := f.(*http2.HeadersFrame)
-				if ok {
-					pass = true
-					break loop
-				}
-			}
+			http2Conn.fr.WriteContinuation(streamID, true, blockFragment[16384:])
 
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, ExpectFrame(http2.FrameHeaders, FlagDefault))
 		},
 	))
 
@@ -75,14 +44,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 		"Sends multiple CONTINUATION frames",
 		"The endpoint must accept the frames.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameHeaders, FlagDefault, ErrCodeDefault},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -98,44 +67,16 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, false, blockFragment[16384:32767])
-			http2Conn.fr.WriteContinuation(1, true, blockFragment[32767:])
-
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-
-				actual = CreateResultFrame(f)
-				_, ok := f.(*http2.HeadersFrame)
-				if ok {
-					pass = true
-					break loop
-				}
-			}
+			http2Conn.fr.WriteContinuation(streamID, false, blockFragment[16384:32767])
+			http2Conn.fr.WriteContinuation(streamID, true, blockFragment[32767:])
 
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, ExpectFrame(http2.FrameHeaders, FlagDefault))
 		},
 	))
 
@@ -143,9 +84,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 		"Sends a CONTINUATION frame followed by any frame other than CONTINUATION",
 		"The endpoint MUST treat as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -161,14 +107,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, false, blockFragment[16384:32767])
-			http2Conn.fr.WriteData(1, true, []byte("test"))
+			http2Conn.fr.WriteContinuation(streamID, false, blockFragment[16384:32767])
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -179,9 +125,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 		"Sends a CONTINUATION frame followed by a frame on a different stream",
 		"The endpoint MUST treat as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -197,14 +148,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, false, blockFragment[16384:32767])
-			http2Conn.fr.WriteContinuation(3, true, blockFragment[32767:])
+			http2Conn.fr.WriteContinuation(streamID, false, blockFragment[16384:32767])
+			http2Conn.fr.WriteContinuation(streamID+2, true, blockFragment[32767:])
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -215,9 +166,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 		"Sends a CONTINUATION frame with the stream identifier that is 0x0",
 		"The endpoint MUST treat as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("x-dummy1", dummyData(4096)))
 			hdrs = append(hdrs, pair("x-dummy2", dummyData(4096)))
@@ -233,13 +189,13 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 			blockFragment := http2Conn.EncodeHeader(hdrs)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = false
 			hp.BlockFragment = blockFragment[0:16384]
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteContinuation(1, false, blockFragment[16384:32767])
+			http2Conn.fr.WriteContinuation(streamID, false, blockFragment[16384:32767])
 			http2Conn.fr.WriteContinuation(0, true, blockFragment[32767:])
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
@@ -251,9 +207,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 		"Sends a CONTINUATION frame after the frame other than HEADERS, PUSH_PROMISE or CONTINUATION",
 		"The endpoint MUST treat as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			// Set INITIAL_WINDOW_SIZE to zero to prevent the peer from closing the stream
 			settings := http2.Setting{http2.SettingInitialWindowSize, 0}
 			http2Conn.fr.WriteSettings(settings)
@@ -261,14 +222,14 @@ func ContinuationTestGroup(ctx *Context) *TestGroup {
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteData(1, false, []byte("test"))
-			http2Conn.fr.WriteContinuation(1, true, http2Conn.EncodeHeader(hdrs))
+			http2Conn.fr.WriteData(streamID, false, []byte("test"))
+			http2Conn.fr.WriteContinuation(streamID, true, http2Conn.EncodeHeader(hdrs))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
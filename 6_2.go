@@ -2,7 +2,6 @@ package h2spec
 
 import (
 	"bytes"
-	"fmt"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 )
@@ -14,18 +13,23 @@ func HeadersTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame followed by any frame other than CONTINUATION",
 		"The endpoint MUST treat the receipt of any other type of frame as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = false
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
-			http2Conn.fr.WriteData(1, true, []byte("test"))
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -36,20 +40,25 @@ func HeadersTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame followed by a frame on a different stream",
 		"The endpoint MUST treat the receipt of a frame on a different stream as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 
 			var hp1 http2.HeadersFrameParam
-			hp1.StreamID = 1
+			hp1.StreamID = streamID
 			hp1.EndStream = false
 			hp1.EndHeaders = false
 			hp1.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp1)
 
 			var hp2 http2.HeadersFrameParam
-			hp2.StreamID = 3
+			hp2.StreamID = streamID + 2
 			hp2.EndStream = true
 			hp2.EndHeaders = true
 			hp2.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -64,7 +73,10 @@ func HeadersTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame with 0x0 stream identifier",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			hdrs := commonHeaderFields(ctx)
@@ -85,9 +97,14 @@ func HeadersTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame with invalid pad length",
 		"The endpoint MUST treat this as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			var buf bytes.Buffer
 			hdrs := commonHeaderFields(ctx)
 			enc := hpack.NewEncoder(&buf)
@@ -96,9 +113,10 @@ func HeadersTestGroup(ctx *Context) *TestGroup {
 			}
 
 			// Payload length: 12, Pad length: 13
-			fmt.Fprintf(http2Conn.conn, "\x00\x00\x0c\x01\x0d\x00\x00\x00\x01")
-			fmt.Fprintf(http2Conn.conn, "\x0d")
-			http2Conn.conn.Write(buf.Bytes())
+			payload := make([]byte, 12)
+			payload[0] = 0x0d
+			copy(payload[1:], buf.Bytes())
+			http2Conn.fr.WriteRawFrame(http2.FrameHeaders, 0x0d, streamID, payload)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -1,7 +1,6 @@
 package h2spec
 
 import (
-	"fmt"
 	"golang.org/x/net/http2"
 )
 
@@ -12,7 +11,10 @@ func RstStreamTestGroup(ctx *Context) *TestGroup {
 		"Sends a RST_STREAM frame with 0x0 stream identifier",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			http2Conn.fr.WriteRSTStream(0, http2.ErrCodeCancel)
@@ -26,10 +28,15 @@ func RstStreamTestGroup(ctx *Context) *TestGroup {
 		"Sends a RST_STREAM frame on a idle stream",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
-			http2Conn.fr.WriteRSTStream(1, http2.ErrCodeCancel)
+			streamID := http2Conn.NextStreamID()
+
+			http2Conn.fr.WriteRSTStream(streamID, http2.ErrCodeCancel)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -40,9 +47,14 @@ func RstStreamTestGroup(ctx *Context) *TestGroup {
 		"Sends a RST_STREAM frame with a length other than 4 octets",
 		"The endpoint MUST respond with a connection error of type FRAME_SIZE_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			// Set INITIAL_WINDOW_SIZE to zero to prevent the peer from closing the stream
 			settings := http2.Setting{http2.SettingInitialWindowSize, 0}
 			http2Conn.fr.WriteSettings(settings)
@@ -50,15 +62,14 @@ func RstStreamTestGroup(ctx *Context) *TestGroup {
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
 
 			// RST_STREAM Frame
-			fmt.Fprintf(http2Conn.conn, "\x00\x00\x03\x03\x00\x00\x00\x00\x01")
-			fmt.Fprintf(http2Conn.conn, "\x00\x00\x00")
+			http2Conn.fr.WriteRawFrame(http2.FrameRSTStream, 0x00, streamID, []byte("\x00\x00\x00"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeFrameSize}
 			return TestConnectionError(ctx, http2Conn, actualCodes)
@@ -2,9 +2,6 @@ package h2spec
 
 import (
 	"fmt"
-	"io"
-	"net"
-	"syscall"
 
 	"golang.org/x/net/http2"
 )
@@ -16,12 +13,10 @@ func SettingsTestGroup(ctx *Context) *TestGroup {
 		"Sends a SETTINGS frame",
 		"The endpoint MUST sends a SETTINGS frame with ACK.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameSettings, http2.FlagSettingsAck, ErrCodeDefault},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
 			settings := []http2.Setting{
@@ -31,41 +26,7 @@ func SettingsTestGroup(ctx *Context) *TestGroup {
 			}
 			http2Conn.fr.WriteSettings(settings...)
 
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-				switch f := f.(type) {
-				case *http2.SettingsFrame:
-					actual = CreateResultFrame(f)
-					if f.IsAck() {
-						pass = true
-						break loop
-					}
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				default:
-					actual = CreateResultFrame(f)
-				}
-			}
-
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, ExpectSettingsAck())
 		},
 	))
 
@@ -73,7 +34,10 @@ func SettingsTestGroup(ctx *Context) *TestGroup {
 		"Sends a SETTINGS frame that is not a zero-length with ACK flag",
 		"The endpoint MUST respond with a connection error of type FRAME_SIZE_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x01\x04\x01\x00\x00\x00\x00\x00")
@@ -87,7 +51,10 @@ func SettingsTestGroup(ctx *Context) *TestGroup {
 		"Sends a SETTINGS frame with the stream identifier that is not 0x0",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x03")
@@ -102,7 +69,10 @@ func SettingsTestGroup(ctx *Context) *TestGroup {
 		"Sends a SETTINGS frame with a length other than a multiple of 6 octets",
 		"The endpoint MUST respond with a connection error of type FRAME_SIZE_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x02\x04\x00\x00\x00\x00\x00")
@@ -125,7 +95,10 @@ func DefinedSettingsParametersTestGroup(ctx *Context) *TestGroup {
 		"SETTINGS_ENABLE_PUSH (0x2): Sends the value other than 0 or 1",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
@@ -140,7 +113,10 @@ func DefinedSettingsParametersTestGroup(ctx *Context) *TestGroup {
 		"SETTINGS_INITIAL_WINDOW_SIZE (0x4): Sends the value above the maximum flow control window size",
 		"The endpoint MUST respond with a connection error of type FLOW_CONTROL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
@@ -155,7 +131,10 @@ func DefinedSettingsParametersTestGroup(ctx *Context) *TestGroup {
 		"SETTINGS_MAX_FRAME_SIZE (0x5): Sends the value below the initial value",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
@@ -170,7 +149,10 @@ func DefinedSettingsParametersTestGroup(ctx *Context) *TestGroup {
 		"SETTINGS_MAX_FRAME_SIZE (0x5): Sends the value above the maximum allowed frame size",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
@@ -1,13 +1,10 @@
 package h2spec
 
 import (
-	"errors"
 	"fmt"
-	"io"
-	"net"
-	"syscall"
 
 	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
 )
 
 func WindowUpdateTestGroup(ctx *Context) *TestGroup {
@@ -17,103 +14,55 @@ func WindowUpdateTestGroup(ctx *Context) *TestGroup {
 		"Sends a WINDOW_UPDATE frame",
 		"The endpoint is expected to send the DATA frame based on the window size.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
 			expected = []Result{
 				&ResultFrame{LengthDefault, http2.FrameData, FlagDefault, ErrCodeDefault},
 			}
 
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			settings := http2.Setting{http2.SettingInitialWindowSize, 1}
 			http2Conn.fr.WriteSettings(settings)
 
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
 
-			winUpdated := false
-
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
+			pass, expected, actual = http2Conn.Expect(ctx, expectResponseHeaders(streamID))
+			if !pass {
+				return pass, expected, actual
+			}
+			if actual.(*ResultFrame).Flags.Has(http2.FlagHeadersEndStream) {
+				return true, nil, &ResultSkipped{"Only received HEADERS frame."}
+			}
 
-				switch f := f.(type) {
-				case *http2.HeadersFrame:
-					if f.StreamEnded() {
-						actual = &ResultSkipped{"Only received HEADERS frame."}
-						return true, nil, actual
-					}
-				case *http2.SettingsFrame:
-					if !f.IsAck() {
-						// What to do about unknown settings?
-					}
-				case *http2.DataFrame:
-					if winUpdated {
-						// Let's skip this test if the DATA frame has END_STREAM flag.
-						if f.FrameHeader.Flags.Has(http2.FlagDataEndStream) {
-							actual = &ResultSkipped{"The length of DATA frame is 0."}
-							return true, nil, actual
-						}
-
-						if f.FrameHeader.Length != 1 {
-							err := errors.New("The length of DATA frame is invalid.")
-							actual = &ResultError{err}
-							break loop
-						}
-
-						actual = CreateResultFrame(f)
-						pass = true
-						break loop
-					} else {
-						// Let's skip this test if the DATA frame has END_STREAM flag.
-						if f.FrameHeader.Flags.Has(http2.FlagDataEndStream) {
-							actual = &ResultSkipped{"The length of DATA frame is 0."}
-							return true, nil, actual
-						}
-
-						if f.FrameHeader.Length != 1 {
-							err := errors.New("The length of DATA frame is invalid.")
-							actual = &ResultError{err}
-							break loop
-						}
-
-						http2Conn.fr.WriteWindowUpdate(1, 1)
-						winUpdated = true
-					}
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				case *http2.RSTStreamFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				default:
-					actual = CreateResultFrame(f)
-				}
+			pass, expected, actual = http2Conn.Expect(ctx, expectDataLength(streamID, 1), expectEmptyStreamEnd(streamID))
+			if !pass {
+				return pass, expected[:1], actual
+			}
+			if rf := actual.(*ResultFrame); rf.Length == 0 {
+				return true, nil, &ResultSkipped{"The length of DATA frame is 0."}
+			} else if rf.Flags.Has(http2.FlagDataEndStream) {
+				return true, nil, &ResultSkipped{"The response body is only 1 octet long."}
 			}
 
-			return pass, expected, actual
+			http2Conn.fr.WriteWindowUpdate(streamID, 1)
+
+			pass, expected, actual = http2Conn.Expect(ctx, expectDataLength(streamID, 1), expectEmptyStreamEnd(streamID))
+			if pass && actual.(*ResultFrame).Length == 0 {
+				return true, nil, &ResultSkipped{"The response body is only 1 octet long."}
+			}
+			return pass, expected[:1], actual
 		},
 	))
 
@@ -121,7 +70,10 @@ func WindowUpdateTestGroup(ctx *Context) *TestGroup {
 		"Sends a WINDOW_UPDATE frame with a flow control window increment of 0",
 		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			http2Conn.fr.WriteWindowUpdate(0, 0)
@@ -135,9 +87,14 @@ func WindowUpdateTestGroup(ctx *Context) *TestGroup {
 		"Sends a WINDOW_UPDATE frame with a flow control window increment of 0 on a stream",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			// Set INITIAL_WINDOW_SIZE to zero to prevent the peer from closing the stream
 			settings := http2.Setting{http2.SettingInitialWindowSize, 0}
 			http2Conn.fr.WriteSettings(settings)
@@ -145,12 +102,12 @@ func WindowUpdateTestGroup(ctx *Context) *TestGroup {
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
-			http2Conn.fr.WriteWindowUpdate(1, 0)
+			http2Conn.fr.WriteWindowUpdate(streamID, 0)
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -161,7 +118,10 @@ func WindowUpdateTestGroup(ctx *Context) *TestGroup {
 		"Sends a WINDOW_UPDATE frame with a length other than a multiple of 4 octets",
 		"The endpoint MUST respond with a connection error of type FRAME_SIZE_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x03\x08\x00\x00\x00\x00\x00")
@@ -185,50 +145,16 @@ func TheFlowControlWindowTestGroup(ctx *Context) *TestGroup {
 		"Sends multiple WINDOW_UPDATE frames on a connection increasing the flow control window to above 2^31-1",
 		"The endpoint MUST sends a GOAWAY frame with a FLOW_CONTROL_ERROR code.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeFlowControl},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
 			http2Conn.fr.WriteWindowUpdate(0, 2147483647)
 			http2Conn.fr.WriteWindowUpdate(0, 2147483647)
 
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-
-				switch f := f.(type) {
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					if f.ErrCode == http2.ErrCodeFlowControl {
-						pass = true
-					}
-					break loop
-				default:
-					actual = CreateResultFrame(f)
-				}
-			}
-
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, ExpectGoAway(http2.ErrCodeFlowControl))
 		},
 	))
 
@@ -236,66 +162,196 @@ func TheFlowControlWindowTestGroup(ctx *Context) *TestGroup {
 		"Sends multiple WINDOW_UPDATE frames on a stream increasing the flow control window to above 2^31-1",
 		"The endpoint MUST send a RST_STREAM with the error code of FLOW_CONTROL_ERROR code.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameRSTStream, FlagDefault, http2.ErrCodeFlowControl},
-				&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeFlowControl},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteWindowUpdate(1, 2147483647)
-			http2Conn.fr.WriteWindowUpdate(1, 2147483647)
+			http2Conn.fr.WriteWindowUpdate(streamID, 2147483647)
+			http2Conn.fr.WriteWindowUpdate(streamID, 2147483647)
+
+			return http2Conn.Expect(ctx,
+				ExpectRstStream(http2.ErrCodeFlowControl),
+				ExpectGoAway(http2.ErrCodeFlowControl),
+			)
+		},
+	))
 
-		loop:
+	tg.AddTestCase(NewTestCase(
+		"Sends DATA frames exceeding the flow control window of a stream",
+		"The endpoint MUST respond with a stream error or a connection error of type FLOW_CONTROL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			_, window := http2Conn.flow.windows(streamID)
+			if window > maxOverrunWindow {
+				return true, nil, &ResultSkipped{"The flow control window of the stream is too large."}
+			}
+
+			hdrs := largeResourceHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			body := []byte(dummyData(int(window) + 1))
+			err = http2Conn.SendBodyOverrun(ctx, streamID, body, false, 1)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+
+			return testFlowControlError(ctx, http2Conn, streamID)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends DATA frames exceeding the flow control window of the connection",
+		"The endpoint MUST respond with a stream error or a connection error of type FLOW_CONTROL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			err = http2Conn.syncWindows(ctx)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+
+			streamID := http2Conn.NextStreamID()
+
+			connWindow, streamWindow := http2Conn.flow.windows(streamID)
+			if connWindow > maxOverrunWindow || streamWindow <= 0 || connWindow/streamWindow >= 100 {
+				return true, nil, &ResultSkipped{"The flow control window of the connection is too large."}
+			}
+
+			hdrs := largeResourceHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+
+			// fill the windows of as many streams as needed, so that
+			// the window of the connection is the smaller one on the
+			// last stream.
 			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
+				var hp http2.HeadersFrameParam
+				hp.StreamID = streamID
+				hp.EndStream = false
+				hp.EndHeaders = true
+				hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+				http2Conn.fr.WriteHeaders(hp)
+
+				connWindow, streamWindow = http2Conn.flow.windows(streamID)
+				if connWindow < streamWindow {
+					break
 				}
 
-				switch f := f.(type) {
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					if f.ErrCode == http2.ErrCodeFlowControl {
-						pass = true
-					}
-					break loop
-				case *http2.RSTStreamFrame:
-					actual = CreateResultFrame(f)
-					if f.ErrCode == http2.ErrCodeFlowControl {
-						pass = true
-					}
-					break loop
-				default:
-					actual = CreateResultFrame(f)
+				body := []byte(dummyData(int(streamWindow)))
+				err = http2Conn.SendBody(ctx, streamID, body, false)
+				if err != nil {
+					return false, expected, &ResultError{err}
 				}
+				streamID += 2
 			}
 
-			return pass, expected, actual
+			body := []byte(dummyData(int(connWindow) + 1))
+			err = http2Conn.SendBodyOverrun(ctx, streamID, body, false, 1)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+
+			return testFlowControlError(ctx, http2Conn, streamID)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a DATA frame exceeding the flow control window of a stream only by its padding",
+		"The endpoint MUST respond with a stream error or a connection error of type FLOW_CONTROL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			_, window := http2Conn.flow.windows(streamID)
+			if window > maxOverrunWindow {
+				return true, nil, &ResultSkipped{"The flow control window of the stream is too large."}
+			}
+
+			hdrs := largeResourceHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			// the last DATA frame fills the rest of the window with
+			// its data and exceeds it with 8 octets of padding, which
+			// is the whole body unless the window is too large for a
+			// frame.
+			last := int(window)
+			if max := http2Conn.maxFrameSize() - 9; last > max {
+				last = max
+			}
+			body := []byte(dummyData(int(window) - last))
+			err = http2Conn.SendBody(ctx, streamID, body, false)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			http2Conn.fr.WriteDataPadded(streamID, false, []byte(dummyData(last)), make([]byte, 8))
+
+			return testFlowControlError(ctx, http2Conn, streamID)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends SETTINGS_INITIAL_WINDOW_SIZE of 0 and requests a resource",
+		"The endpoint MUST NOT send DATA frames until the window is updated.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testSendWindow(ctx, 0)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends SETTINGS_INITIAL_WINDOW_SIZE of 1 and requests a resource",
+		"The endpoint MUST NOT send DATA frames exceeding the flow control window.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testSendWindow(ctx, 1)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends SETTINGS_INITIAL_WINDOW_SIZE of 1024 and requests a resource",
+		"The endpoint MUST NOT send DATA frames exceeding the flow control window.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testSendWindow(ctx, 1024)
 		},
 	))
 
@@ -309,7 +365,10 @@ func InitialFlowControlWindowSizeTestGroup(ctx *Context) *TestGroup {
 		"Sends a SETTINGS_INITIAL_WINDOW_SIZE settings with an exceeded maximum window size value",
 		"The endpoint MUST respond with a connection error of type FLOW_CONTROL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
 			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
@@ -320,5 +379,340 @@ func InitialFlowControlWindowSizeTestGroup(ctx *Context) *TestGroup {
 		},
 	))
 
+	tg.AddTestCase(NewTestCase(
+		"Changes SETTINGS_INITIAL_WINDOW_SIZE after sending HEADERS frame",
+		"The endpoint MUST adjust the size of all stream flow control windows.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			// block the response body until the window is adjusted.
+			http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, 0})
+
+			hdrs := largeResourceHeaderFields(ctx)
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			pass, expected, actual = http2Conn.Expect(ctx, expectResponseHeaders(streamID))
+			if !pass {
+				return pass, expected, actual
+			}
+			if actual.(*ResultFrame).Flags.Has(http2.FlagHeadersEndStream) {
+				return true, nil, &ResultSkipped{"Only received HEADERS frame."}
+			}
+
+			http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, 1})
+
+			return http2Conn.Expect(ctx, expectDataLength(streamID, 1))
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a SETTINGS frame for window size to be negative",
+		"The endpoint MUST track the negative flow control window.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, 3})
+
+			hdrs := largeResourceHeaderFields(ctx)
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			pass, expected, actual = http2Conn.Expect(ctx, expectDataLength(streamID, 3))
+			if !pass {
+				return pass, expected, actual
+			}
+			if actual.(*ResultFrame).Flags.Has(http2.FlagDataEndStream) {
+				return true, nil, &ResultSkipped{"The response body is too short."}
+			}
+
+			// the window becomes -1 by the new initial window size,
+			// and 1 by the window update.
+			http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, 2})
+			http2Conn.fr.WriteWindowUpdate(streamID, 2)
+
+			return http2Conn.Expect(ctx, expectDataLength(streamID, 1))
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Reduces SETTINGS_INITIAL_WINDOW_SIZE while receiving a response",
+		"The endpoint MUST NOT send DATA frames exceeding the reduced flow control window.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, 1024})
+
+			hdrs := largeResourceHeaderFields(ctx)
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			pass, expected, actual = http2Conn.Expect(ctx, ExpectFrame(http2.FrameData, FlagDefault))
+			if !pass {
+				return pass, expected, actual
+			}
+			if actual.(*ResultFrame).Flags.Has(http2.FlagDataEndStream) {
+				return true, nil, &ResultSkipped{smallResourceReason}
+			}
+			octets := int64(actual.(*ResultFrame).Length)
+			if octets > 1024 {
+				return false, []Result{&ResultFlowControl{-1, 1024}}, &ResultFlowControl{octets, 1024}
+			}
+
+			// the DATA frames received before the SETTINGS frame is
+			// acknowledged may have been sent on the old window.  The
+			// window is 512 - sent after that, and may be negative.
+			http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, 512})
+
+			acked := false
+			var limit int64 = 1024
+			ended, actual := receiveData(ctx, http2Conn, streamID, &octets, &limit, func() {
+				if !acked {
+					acked = true
+					limit = octets
+					if limit < 512 {
+						limit = 512
+					}
+				}
+			})
+
+			expected = []Result{&ResultFlowControl{-1, limit}}
+			if actual != nil {
+				return false, expected, actual
+			}
+			if ended && octets <= 512 {
+				return true, nil, &ResultSkipped{smallResourceReason}
+			}
+			return true, expected, &ResultFlowControl{octets, limit}
+		},
+	))
+
 	return tg
 }
+
+// smallResourceReason is the reason for skipping the flow control test
+// cases when the response fits in the window.
+const smallResourceReason = "The response body is too small. Use --path to request a larger resource."
+
+// largeResourceHeaderFields returns the header fields of a request for
+// the resource given by --path, which should be large enough to exhaust
+// the flow control windows.
+func largeResourceHeaderFields(ctx *Context) []hpack.HeaderField {
+	hdrs := commonHeaderFields(ctx)
+	if ctx.Path != "" {
+		hdrs[2] = pair(":path", ctx.Path)
+	}
+	return hdrs
+}
+
+// testSendWindow tests that the endpoint sends no more DATA frames than
+// the stream flow control window of window octets allows, until the
+// window is updated.
+func testSendWindow(ctx *Context, window uint32) (pass bool, expected []Result, actual Result) {
+	http2Conn, err := CreateHttp2Conn(ctx, true)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer http2Conn.conn.Close()
+
+	streamID := http2Conn.NextStreamID()
+
+	http2Conn.fr.WriteSettings(http2.Setting{http2.SettingInitialWindowSize, window})
+
+	hdrs := largeResourceHeaderFields(ctx)
+
+	var hp http2.HeadersFrameParam
+	hp.StreamID = streamID
+	hp.EndStream = true
+	hp.EndHeaders = true
+	hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+	http2Conn.fr.WriteHeaders(hp)
+
+	var octets int64
+	limit := int64(window)
+	expected = []Result{&ResultFlowControl{-1, limit}}
+
+	ended, actual := receiveData(ctx, http2Conn, streamID, &octets, &limit, nil)
+	if actual != nil {
+		return false, expected, actual
+	}
+	if ended {
+		return true, nil, &ResultSkipped{smallResourceReason}
+	}
+
+	// the endpoint must resume sending once the window is updated.
+	received := octets
+	limit += 1024
+	expected = []Result{&ResultFlowControl{-1, limit}}
+	http2Conn.fr.WriteWindowUpdate(streamID, 1024)
+
+	_, actual = receiveData(ctx, http2Conn, streamID, &octets, &limit, nil)
+	if actual != nil {
+		return false, expected, actual
+	}
+	if octets == received {
+		return false, expected, &ResultTestTimeout{}
+	}
+
+	return true, expected, &ResultFlowControl{octets, limit}
+}
+
+// receiveData reads frames until the timeout or the end of the stream,
+// and adds the length of DATA frames on streamID to octets.  It returns
+// the result of the test case if the endpoint sent more than limit
+// octets, or gave up.  ended is true if the endpoint ended the stream.
+// ack is called for each SETTINGS frame with ACK flag if not nil.
+func receiveData(ctx *Context, http2Conn *Http2Conn, streamID uint32, octets, limit *int64, ack func()) (ended bool, actual Result) {
+	pass, _, actual := http2Conn.Expect(ctx, expectData(streamID, octets, limit, ack), ExpectTimeout())
+	if !pass {
+		if *octets > *limit {
+			return false, &ResultFlowControl{*octets, *limit}
+		}
+		return false, actual
+	}
+
+	_, ended = actual.(*ResultStreamClose)
+	return ended, nil
+}
+
+// expectData expects the end of the stream, and adds the length of
+// DATA frames on streamID to octets on the way.  More than limit octets
+// or a GOAWAY or RST_STREAM frame rules it out, and any other frame is
+// skipped.  ack is called for each SETTINGS frame with ACK flag if not
+// nil.
+func expectData(streamID uint32, octets, limit *int64, ack func()) Expectation {
+	exp := ExpectStreamClose(streamID)
+	streamClosed := exp.match
+	exp.match = func(f http2.Frame) bool {
+		switch f := f.(type) {
+		case *http2.DataFrame:
+			if f.StreamID == streamID {
+				*octets += int64(f.Length)
+			}
+		case *http2.SettingsFrame:
+			if f.IsAck() && ack != nil {
+				ack()
+			}
+		}
+		return *octets <= *limit && streamClosed(f)
+	}
+	exp.fail = func(f http2.Frame) bool {
+		return *octets > *limit || gaveUp(f)
+	}
+	exp.skip = func(f http2.Frame) bool {
+		return !exp.fail(f)
+	}
+	return exp
+}
+
+// maxOverrunWindow is the largest flow control window which the test
+// cases fill to exceed it.
+const maxOverrunWindow = 1 << 24
+
+// testFlowControlError tests the reaction of the endpoint to DATA
+// frames exceeding a flow control window on streamID.  The test case is
+// skipped if the endpoint closed the stream before the window was
+// exceeded.  A reaction other than FLOW_CONTROL_ERROR raises only an
+// advisory if the endpoint closed the stream or updated the windows
+// after the DATA frames were sent, since they may have crossed.
+func testFlowControlError(ctx *Context, http2Conn *Http2Conn, streamID uint32) (pass bool, expected []Result, actual Result) {
+	if !http2Conn.flow.exceeded() {
+		return true, nil, &ResultSkipped{"The endpoint closed the stream before the window was exceeded."}
+	}
+
+	pass, expected, actual = http2Conn.Expect(ctx,
+		ExpectRstStream(http2.ErrCodeFlowControl),
+		ExpectGoAway(http2.ErrCodeFlowControl),
+	)
+	if pass {
+		return pass, expected, actual
+	}
+
+	switch rf := actual.(type) {
+	case *ResultFrame:
+		if rf.Type == http2.FrameRSTStream && rf.ErrCode == http2.ErrCodeNo {
+			return pass, expected, &ResultInconclusive{actual, "The endpoint closed the stream while the DATA frames were in flight."}
+		}
+		if rf.Type == http2.FrameRSTStream || rf.Type == http2.FrameGoAway {
+			return pass, expected, actual
+		}
+	case *ResultTestTimeout:
+	default:
+		return pass, expected, actual
+	}
+
+	// the windows were exceeded when the DATA frames were sent, but
+	// the window updates received since may have been in flight.
+	connWindow, streamWindow := http2Conn.flow.windows(streamID)
+	if connWindow >= 0 && streamWindow >= 0 {
+		return pass, expected, &ResultInconclusive{actual, "The endpoint updated the window while the DATA frames were in flight."}
+	}
+
+	return pass, expected, actual
+}
+
+// expectResponseHeaders expects the HEADERS frame of the response on
+// streamID.
+func expectResponseHeaders(streamID uint32) Expectation {
+	result := &ResultFrame{LengthDefault, http2.FrameHeaders, FlagDefault, ErrCodeDefault}
+	return ExpectFunc(http2.FrameHeaders, result, func(f http2.Frame) bool {
+		return f.Header().StreamID == streamID
+	})
+}
+
+// expectEmptyStreamEnd expects a DATA frame of length 0 with
+// END_STREAM flag on streamID, which ends a response without using the
+// flow control window.
+func expectEmptyStreamEnd(streamID uint32) Expectation {
+	result := &ResultFrame{0, http2.FrameData, http2.FlagDataEndStream, ErrCodeDefault}
+	return ExpectFunc(http2.FrameData, result, func(f http2.Frame) bool {
+		df := f.(*http2.DataFrame)
+		return df.StreamID == streamID && df.StreamEnded() && df.Length == 0
+	})
+}
+
+// expectDataLength expects a DATA frame of length octets on streamID.
+// A DATA frame of another length rules out the expectation.
+func expectDataLength(streamID uint32, length uint32) Expectation {
+	result := &ResultFrame{length, http2.FrameData, FlagDefault, ErrCodeDefault}
+	exp := ExpectFunc(http2.FrameData, result, func(f http2.Frame) bool {
+		return f.Header().StreamID == streamID && f.Header().Length == length
+	})
+	exp.fail = func(f http2.Frame) bool {
+		return gaveUp(f) || f.Header().Type == http2.FrameData
+	}
+	return exp
+}
@@ -0,0 +1,111 @@
+package h2spec
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// serveResponse answers the settings of the client, and calls respond
+// with the stream of every request.  Frames which respond does not read
+// are ignored.
+func serveResponse(respond func(fr *http2.Framer, streamID uint32)) func(fr *http2.Framer) {
+	return func(fr *http2.Framer) {
+		fr.WriteSettings()
+
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			switch f := f.(type) {
+			case *http2.SettingsFrame:
+				if !f.IsAck() {
+					fr.WriteSettingsAck()
+				}
+			case *http2.HeadersFrame:
+				respond(fr, f.StreamID)
+			}
+		}
+	}
+}
+
+// writeResponseHeaders writes the HEADERS frame of a 200 response.
+func writeResponseHeaders(fr *http2.Framer, streamID uint32, endStream bool) {
+	var buf bytes.Buffer
+	hpack.NewEncoder(&buf).WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+	fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: buf.Bytes(),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	})
+}
+
+func TestWindowUpdateResponse(t *testing.T) {
+	const desc = "Sends a WINDOW_UPDATE frame"
+
+	tests := []struct {
+		name    string
+		respond func(fr *http2.Framer, streamID uint32)
+		pass    bool
+		skipped bool
+	}{
+		{
+			name: "DATA frames of 1 octet",
+			respond: func(fr *http2.Framer, streamID uint32) {
+				writeResponseHeaders(fr, streamID, false)
+				fr.WriteData(streamID, false, []byte("a"))
+				for {
+					f, err := fr.ReadFrame()
+					if err != nil {
+						return
+					}
+					if _, ok := f.(*http2.WindowUpdateFrame); ok {
+						fr.WriteData(streamID, true, []byte("b"))
+						return
+					}
+				}
+			},
+			pass: true,
+		},
+		{
+			name: "empty DATA frame ending the stream",
+			respond: func(fr *http2.Framer, streamID uint32) {
+				writeResponseHeaders(fr, streamID, false)
+				fr.WriteData(streamID, true, nil)
+			},
+			pass:    true,
+			skipped: true,
+		},
+		{
+			name: "HEADERS frame ending the stream",
+			respond: func(fr *http2.Framer, streamID uint32) {
+				writeResponseHeaders(fr, streamID, true)
+			},
+			pass:    true,
+			skipped: true,
+		},
+		{
+			name: "DATA frame exceeding the window",
+			respond: func(fr *http2.Framer, streamID uint32) {
+				writeResponseHeaders(fr, streamID, false)
+				fr.WriteData(streamID, true, []byte("ab"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		ctx := serveTestConn(t, serveResponse(tt.respond))
+		tc := findTestCase(t, WindowUpdateTestGroup(ctx), desc)
+		pass, _, actual := tc.handler(ctx)
+
+		_, skipped := actual.(*ResultSkipped)
+		if pass != tt.pass || skipped != tt.skipped {
+			t.Errorf("%s: got pass %v and %v", tt.name, pass, actual)
+		}
+	}
+}
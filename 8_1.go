@@ -1,9 +1,8 @@
 package h2spec
 
 import (
-	"io"
-	"net"
-	"syscall"
+	"fmt"
+	"strconv"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -16,69 +15,25 @@ func HttpRequestResponseExchangeTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame as HEAD request",
 		"The endpoint should respond with no DATA frame or empty DATA frame.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameHeaders, http2.FlagHeadersEndStream, ErrCodeDefault},
-				&ResultFrame{0, http2.FrameData, http2.FlagDataEndStream, ErrCodeDefault},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs[0].Value = "HEAD"
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
 
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-
-				switch f := f.(type) {
-				case *http2.DataFrame:
-					actual = CreateResultFrame(f)
-					if f.FrameHeader.Flags.Has(http2.FlagDataEndStream) && f.Header().Length == 0 {
-						pass = true
-						break loop
-					}
-				case *http2.HeadersFrame:
-					actual = CreateResultFrame(f)
-					if f.FrameHeader.Flags.Has(http2.FlagHeadersEndStream) {
-						pass = true
-						break loop
-					}
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				case *http2.RSTStreamFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				}
-				actual = CreateResultFrame(f)
-			}
-
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, expectHeadResponse()...)
 		},
 	))
 
@@ -86,14 +41,14 @@ func HttpRequestResponseExchangeTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame containing trailer part",
 		"The endpoint should respond with HEADERS frame.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			pass = false
-			expected = []Result{
-				&ResultFrame{LengthDefault, http2.FrameHeaders, http2.FlagHeadersEndStream, ErrCodeDefault},
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
 			}
-
-			http2Conn := CreateHttp2Conn(ctx, true)
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs[0].Value = "POST"
 			hdrs = append(hdrs, pair("content-length", "4"))
@@ -101,63 +56,26 @@ func HttpRequestResponseExchangeTestGroup(ctx *Context) *TestGroup {
 			hdrs = append(hdrs, pair("trailer", "x-test"))
 
 			var hp1 http2.HeadersFrameParam
-			hp1.StreamID = 1
+			hp1.StreamID = streamID
 			hp1.EndStream = false
 			hp1.EndHeaders = true
 			hp1.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp1)
 
-			http2Conn.fr.WriteData(1, false, []byte("test"))
+			http2Conn.fr.WriteData(streamID, false, []byte("test"))
 
 			trailers := []hpack.HeaderField{
 				pair("x-test", "ok"),
 			}
 
 			var hp2 http2.HeadersFrameParam
-			hp2.StreamID = 1
+			hp2.StreamID = streamID
 			hp2.EndStream = true
 			hp2.EndHeaders = true
 			hp2.BlockFragment = http2Conn.EncodeHeader(trailers)
 			http2Conn.fr.WriteHeaders(hp2)
 
-		loop:
-			for {
-				f, err := http2Conn.ReadFrame(ctx.Timeout)
-				if err != nil {
-					opErr, ok := err.(*net.OpError)
-					if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-						rf, ok := actual.(*ResultFrame)
-						if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-							actual = &ResultConnectionClose{}
-						}
-					} else if err == TIMEOUT {
-						if actual == nil {
-							actual = &ResultTestTimeout{}
-						}
-					} else {
-						actual = &ResultError{err}
-					}
-					break loop
-				}
-
-				switch f := f.(type) {
-				case *http2.HeadersFrame:
-					actual = CreateResultFrame(f)
-					if f.FrameHeader.Flags.Has(http2.FlagHeadersEndHeaders) {
-						pass = true
-						break loop
-					}
-				case *http2.GoAwayFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				case *http2.RSTStreamFrame:
-					actual = CreateResultFrame(f)
-					break loop
-				}
-				actual = CreateResultFrame(f)
-			}
-
-			return pass, expected, actual
+			return http2Conn.Expect(ctx, ExpectFrame(http2.FrameHeaders, http2.FlagHeadersEndHeaders))
 		},
 	))
 
@@ -165,9 +83,14 @@ func HttpRequestResponseExchangeTestGroup(ctx *Context) *TestGroup {
 		"Sends a second HEADERS frame without the END_STREAM flag",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			// Set INITIAL_WINDOW_SIZE to zero to prevent the peer from closing the stream
 			settings := http2.Setting{http2.SettingInitialWindowSize, 0}
 			http2Conn.fr.WriteSettings(settings)
@@ -177,13 +100,13 @@ func HttpRequestResponseExchangeTestGroup(ctx *Context) *TestGroup {
 			hdrs = append(hdrs, pair("trailer", "x-test"))
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
 
-			http2Conn.fr.WriteData(1, false, []byte("test"))
+			http2Conn.fr.WriteData(streamID, false, []byte("test"))
 
 			trailers := []hpack.HeaderField{
 				pair("x-test", "ok"),
@@ -208,14 +131,19 @@ func HttpHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the header field name in uppercase letters",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("X-TEST", "test"))
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -226,6 +154,164 @@ func HttpHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		},
 	))
 
+	tg.AddTestCase(NewTestCase(
+		"Sends a GET request",
+		"The endpoint MUST respond with a well-formed response.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			hdrs := commonHeaderFields(ctx)
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			return testWellFormedResponse(ctx, http2Conn, streamID, false)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a HEAD request",
+		"The endpoint MUST respond with a well-formed response without DATA payload.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			hdrs := commonHeaderFields(ctx)
+			hdrs[0].Value = "HEAD"
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			return testWellFormedResponse(ctx, http2Conn, streamID, true)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a POST request with a body",
+		"The endpoint MUST respond with a well-formed response.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			hdrs := commonHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+			hdrs = append(hdrs, pair("content-length", "4"))
+			hdrs = append(hdrs, pair("content-type", "text/plain"))
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
+
+			return testWellFormedResponse(ctx, http2Conn, streamID, false)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a POST request with a body larger than the default initial window size",
+		"The endpoint MUST respond with a well-formed response.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			body := []byte(dummyData(1 << 17))
+
+			hdrs := commonHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+			hdrs = append(hdrs, pair("content-length", strconv.Itoa(len(body))))
+			hdrs = append(hdrs, pair("content-type", "text/plain"))
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			err = http2Conn.SendBody(ctx, streamID, body, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+
+			return testWellFormedResponse(ctx, http2Conn, streamID, false)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a POST request with trailers",
+		"The endpoint MUST respond with a well-formed response.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			hdrs := commonHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+			hdrs = append(hdrs, pair("content-length", "4"))
+			hdrs = append(hdrs, pair("content-type", "text/plain"))
+			hdrs = append(hdrs, pair("trailer", "x-test"))
+
+			var hp1 http2.HeadersFrameParam
+			hp1.StreamID = streamID
+			hp1.EndStream = false
+			hp1.EndHeaders = true
+			hp1.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp1)
+
+			http2Conn.fr.WriteData(streamID, false, []byte("test"))
+
+			trailers := []hpack.HeaderField{
+				pair("x-test", "ok"),
+			}
+
+			var hp2 http2.HeadersFrameParam
+			hp2.StreamID = streamID
+			hp2.EndStream = true
+			hp2.EndHeaders = true
+			hp2.BlockFragment = http2Conn.EncodeHeader(trailers)
+			http2Conn.fr.WriteHeaders(hp2)
+
+			return testWellFormedResponse(ctx, http2Conn, streamID, false)
+		},
+	))
+
 	tg.AddTestGroup(PseudoHeaderFieldsTestGroup(ctx))
 	tg.AddTestGroup(ConnectionSpecificHeaderFieldsTestGroup(ctx))
 	tg.AddTestGroup(RequestPseudoHeaderFieldsTestGroup(ctx))
@@ -241,14 +327,19 @@ func PseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the pseudo-header field defined for response",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair(":status", "200"))
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -263,14 +354,19 @@ func PseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the invalid pseudo-header field",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair(":test", "test"))
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -285,9 +381,14 @@ func PseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains a pseudo-header field that appears in a header block after a regular header field",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			tmp := []hpack.HeaderField{
 				pair("x-test", "test"),
@@ -295,7 +396,7 @@ func PseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 			hdrs = append(tmp, hdrs...)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -316,14 +417,19 @@ func ConnectionSpecificHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the connection-specific header field",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("connection", "keep-alive"))
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -338,15 +444,20 @@ func ConnectionSpecificHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the TE header field that contain any value other than \"trailers\"",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("trailers", "test"))
 			hdrs = append(hdrs, pair("te", "trailers, deflate"))
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -367,15 +478,20 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that omits mandatory pseudo-header fields",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := []hpack.HeaderField{
 				commonHeaderFieldAuthority(ctx),
 			}
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -390,9 +506,14 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that omits just ':method' pseudo-header field.",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := []hpack.HeaderField{
 				commonHeaderFieldScheme(ctx),
 				commonHeaderFieldPath(),
@@ -400,7 +521,7 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 			}
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -415,9 +536,14 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that omits just ':scheme' pseudo-header field.",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := []hpack.HeaderField{
 				commonHeaderFieldMethod(),
 				commonHeaderFieldPath(),
@@ -425,7 +551,7 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 			}
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -440,9 +566,14 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that omits just ':path' pseudo-header field.",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := []hpack.HeaderField{
 				commonHeaderFieldMethod(),
 				commonHeaderFieldScheme(ctx),
@@ -450,7 +581,7 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 			}
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -465,15 +596,20 @@ func RequestPseudoHeaderFieldsTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame containing more than one pseudo-header fields with the same name",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs1 := commonHeaderFields(ctx)
 			hdrs2 := commonHeaderFields(ctx)
 			hdrs := append(hdrs1, hdrs2...)
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = true
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
@@ -494,20 +630,25 @@ func MalformedRequestsAndResponsesTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the \"content-length\" header field which does not equal the sum of the DATA frame payload lengths",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("content-length", "1"))
 			hdrs[0].Value = "POST"
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
-			http2Conn.fr.WriteData(1, true, []byte("test"))
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -518,21 +659,26 @@ func MalformedRequestsAndResponsesTestGroup(ctx *Context) *TestGroup {
 		"Sends a HEADERS frame that contains the \"content-length\" header field which does not equal the sum of the multiple DATA frame payload lengths",
 		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			hdrs := commonHeaderFields(ctx)
 			hdrs = append(hdrs, pair("content-length", "1"))
 			hdrs[0].Value = "POST"
 
 			var hp http2.HeadersFrameParam
-			hp.StreamID = 1
+			hp.StreamID = streamID
 			hp.EndStream = false
 			hp.EndHeaders = true
 			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
 			http2Conn.fr.WriteHeaders(hp)
-			http2Conn.fr.WriteData(1, false, []byte("test"))
-			http2Conn.fr.WriteData(1, true, []byte("test"))
+			http2Conn.fr.WriteData(streamID, false, []byte("test"))
+			http2Conn.fr.WriteData(streamID, true, []byte("test"))
 
 			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
 			return TestStreamError(ctx, http2Conn, actualCodes)
@@ -541,3 +687,108 @@ func MalformedRequestsAndResponsesTestGroup(ctx *Context) *TestGroup {
 
 	return tg
 }
+
+// testWellFormedResponse reads the response on streamID until the
+// stream is closed, and checks the header lists of the response and
+// the length of its payload.  head indicates that the request was a
+// HEAD request, whose response must not carry DATA payload.
+func testWellFormedResponse(ctx *Context, http2Conn *Http2Conn, streamID uint32, head bool) (pass bool, expected []Result, actual Result) {
+	// count the payload on the way to the end of the stream, which
+	// must not be reset.
+	length := 0
+	exp := ExpectStreamClose(streamID)
+	streamClosed := exp.match
+	exp.match = func(f http2.Frame) bool {
+		if df, ok := f.(*http2.DataFrame); ok && df.StreamID == streamID {
+			length += len(df.Data())
+		}
+		return streamClosed(f)
+	}
+	exp.fail = gaveUp
+
+	pass, expected, actual = http2Conn.Expect(ctx, exp)
+	if !pass {
+		return false, expected, actual
+	}
+
+	// wait for the rest of a header block split into CONTINUATION
+	// frames.
+	for http2Conn.headerStreamID != 0 {
+		if _, err := http2Conn.ReadFrame(ctx.Timeout); err != nil {
+			return false, expected, &ResultError{err}
+		}
+	}
+
+	if err := http2Conn.HeaderError(); err != nil {
+		return false, expected, &ResultError{err}
+	}
+
+	if err := checkResponse(http2Conn.HeaderLists(streamID), length, head); err != nil {
+		return false, expected, &ResultMalformedHeader{err.Error()}
+	}
+
+	return true, expected, &ResultStreamClose{}
+}
+
+// expectHeadResponse returns the expectations for the response to a
+// HEAD request, which carries no payload.
+func expectHeadResponse() []Expectation {
+	emptyData := &ResultFrame{0, http2.FrameData, http2.FlagDataEndStream, ErrCodeDefault}
+	return []Expectation{
+		ExpectFrame(http2.FrameHeaders, http2.FlagHeadersEndStream),
+		ExpectFunc(http2.FrameData, emptyData, func(f http2.Frame) bool {
+			return f.(*http2.DataFrame).StreamEnded() && f.Header().Length == 0
+		}),
+	}
+}
+
+// checkResponse checks the header lists of a response, which consist
+// of any number of informational (1xx) responses, the final response
+// and optional trailers, against the length of the DATA payload.
+func checkResponse(hls [][]hpack.HeaderField, length int, head bool) error {
+	var final []hpack.HeaderField
+
+	for _, hl := range hls {
+		if final != nil {
+			if err := CheckTrailer(hl); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := CheckResponseHeader(hl); err != nil {
+			return err
+		}
+		if status := hl[0].Value; status[0] != '1' || status == "101" {
+			final = hl
+		}
+	}
+
+	if final == nil {
+		return fmt.Errorf("final response is missing")
+	}
+
+	if head {
+		if length > 0 {
+			return fmt.Errorf("response to HEAD request carries %d octets of DATA payload", length)
+		}
+		return nil
+	}
+
+	status := final[0].Value
+	for _, hf := range final {
+		if hf.Name != "content-length" || status == "204" || status == "304" {
+			continue
+		}
+
+		cl, err := strconv.Atoi(hf.Value)
+		if err != nil {
+			return fmt.Errorf("content-length %q is not a number", hf.Value)
+		}
+		if cl != length {
+			return fmt.Errorf("content-length %d does not match %d octets of DATA payload", cl, length)
+		}
+	}
+
+	return nil
+}
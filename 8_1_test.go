@@ -0,0 +1,162 @@
+package h2spec
+
+import (
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// headerList returns the header fields given as name and value pairs.
+func headerList(pairs ...string) []hpack.HeaderField {
+	var hl []hpack.HeaderField
+	for i := 0; i+1 < len(pairs); i += 2 {
+		hl = append(hl, hpack.HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return hl
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		hls    [][]hpack.HeaderField
+		length int
+		head   bool
+		ok     bool
+	}{
+		{
+			name:   "final response",
+			hls:    [][]hpack.HeaderField{headerList(":status", "200", "content-type", "text/plain")},
+			length: 5,
+			ok:     true,
+		},
+		{
+			name:   "matching content-length",
+			hls:    [][]hpack.HeaderField{headerList(":status", "200", "content-length", "5")},
+			length: 5,
+			ok:     true,
+		},
+		{
+			name:   "content-length larger than the DATA payload",
+			hls:    [][]hpack.HeaderField{headerList(":status", "200", "content-length", "6")},
+			length: 5,
+		},
+		{
+			name:   "content-length smaller than the DATA payload",
+			hls:    [][]hpack.HeaderField{headerList(":status", "200", "content-length", "4")},
+			length: 5,
+		},
+		{
+			name: "content-length which is not a number",
+			hls:  [][]hpack.HeaderField{headerList(":status", "200", "content-length", "five")},
+		},
+		{
+			name: "content-length of a 204 response",
+			hls:  [][]hpack.HeaderField{headerList(":status", "204", "content-length", "5")},
+			ok:   true,
+		},
+		{
+			name: "content-length of a 304 response",
+			hls:  [][]hpack.HeaderField{headerList(":status", "304", "content-length", "5")},
+			ok:   true,
+		},
+		{
+			name: "content-length of a response to HEAD request",
+			hls:  [][]hpack.HeaderField{headerList(":status", "200", "content-length", "5")},
+			head: true,
+			ok:   true,
+		},
+		{
+			name:   "DATA payload of a response to HEAD request",
+			hls:    [][]hpack.HeaderField{headerList(":status", "200")},
+			length: 1,
+			head:   true,
+		},
+		{
+			name: "informational responses before the final response",
+			hls: [][]hpack.HeaderField{
+				headerList(":status", "100"),
+				headerList(":status", "103", "link", "</style.css>; rel=preload"),
+				headerList(":status", "200", "content-length", "0"),
+			},
+			ok: true,
+		},
+		{
+			name: "informational response only",
+			hls:  [][]hpack.HeaderField{headerList(":status", "100")},
+		},
+		{
+			name: "no header list",
+		},
+		{
+			name: "101 as the final response",
+			hls:  [][]hpack.HeaderField{headerList(":status", "101")},
+			ok:   true,
+		},
+		{
+			name: "trailers",
+			hls: [][]hpack.HeaderField{
+				headerList(":status", "200"),
+				headerList("x-checksum", "0"),
+			},
+			ok: true,
+		},
+		{
+			name: "pseudo-header field in trailers",
+			hls: [][]hpack.HeaderField{
+				headerList(":status", "200"),
+				headerList(":status", "200"),
+			},
+		},
+		{
+			name: "connection-specific header field in trailers",
+			hls: [][]hpack.HeaderField{
+				headerList(":status", "200"),
+				headerList("connection", "close"),
+			},
+		},
+		{
+			name: "malformed informational response",
+			hls: [][]hpack.HeaderField{
+				headerList(":status", "100", "Foo", "bar"),
+				headerList(":status", "200"),
+			},
+		},
+		{
+			name: "missing :status",
+			hls:  [][]hpack.HeaderField{headerList("content-type", "text/plain")},
+		},
+		{
+			name: "pseudo-header field after regular header fields",
+			hls:  [][]hpack.HeaderField{headerList("content-type", "text/plain", ":status", "200")},
+		},
+		{
+			name: "request pseudo-header field",
+			hls:  [][]hpack.HeaderField{headerList(":status", "200", ":path", "/")},
+		},
+		{
+			name: "duplicated :status",
+			hls:  [][]hpack.HeaderField{headerList(":status", "200", ":status", "204")},
+		},
+		{
+			name: ":status which is not a 3-digit status code",
+			hls:  [][]hpack.HeaderField{headerList(":status", "2000")},
+		},
+		{
+			name: "uppercase header field name",
+			hls:  [][]hpack.HeaderField{headerList(":status", "200", "Content-Type", "text/plain")},
+		},
+		{
+			name: "te header field other than trailers",
+			hls:  [][]hpack.HeaderField{headerList(":status", "200", "te", "gzip")},
+		},
+	}
+
+	for _, tt := range tests {
+		err := checkResponse(tt.hls, tt.length, tt.head)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
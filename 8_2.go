@@ -13,9 +13,14 @@ func ServerPushTestGroup(ctx *Context) *TestGroup {
 		"Sends a PUSH_PROMISE frame",
 		"The endpoint MUST treat the receipt of a PUSH_PROMISE frame as a connection error of type PROTOCOL_ERROR.",
 		func(ctx *Context) (pass bool, expected []Result, actual Result) {
-			http2Conn := CreateHttp2Conn(ctx, true)
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
 			defer http2Conn.conn.Close()
 
+			streamID := http2Conn.NextStreamID()
+
 			var buf bytes.Buffer
 			hdrs := commonHeaderFields(ctx)
 			enc := hpack.NewEncoder(&buf)
@@ -24,8 +29,8 @@ func ServerPushTestGroup(ctx *Context) *TestGroup {
 			}
 
 			var pp http2.PushPromiseParam
-			pp.StreamID = 1
-			pp.PromiseID = 3
+			pp.StreamID = streamID
+			pp.PromiseID = streamID + 2
 			pp.EndHeaders = true
 			pp.BlockFragment = buf.Bytes()
 			http2Conn.fr.WritePushPromise(pp)
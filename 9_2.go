@@ -0,0 +1,459 @@
+package h2spec
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func UseOfTlsFeaturesTestGroup(ctx *Context) *TestGroup {
+	if !ctx.Tls {
+		return nil
+	}
+
+	tg := NewTestGroup("9.2", "Use of TLS Features")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a TLS 1.1 ClientHello offering h2",
+		"The endpoint MUST refuse the handshake, not negotiate HTTP/2, or respond with a connection error of type INADEQUATE_SECURITY.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testInadequateSecurity(ctx, func(config *tls.Config) {
+				config.MinVersion = tls.VersionTLS10
+				config.MaxVersion = tls.VersionTLS11
+			})
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a TLS 1.0 ClientHello offering h2",
+		"The endpoint MUST refuse the handshake, not negotiate HTTP/2, or respond with a connection error of type INADEQUATE_SECURITY.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testInadequateSecurity(ctx, func(config *tls.Config) {
+				config.MinVersion = tls.VersionTLS10
+				config.MaxVersion = tls.VersionTLS10
+			})
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Offers h2 with ALPN over TLS 1.1",
+		"The endpoint is expected not to select h2 unless TLS 1.2 or higher is negotiated.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultHandshakeFailure{},
+				&ResultNegotiatedProtocol{},
+			}
+
+			conn, err := handshakeTls(ctx, func(config *tls.Config) {
+				config.MinVersion = tls.VersionTLS10
+				config.MaxVersion = tls.VersionTLS11
+			})
+			if err != nil {
+				if !isTlsAlert(err) {
+					return false, expected, &ResultError{err}
+				}
+				return true, expected, &ResultHandshakeFailure{err}
+			}
+			defer conn.Close()
+
+			protocol := conn.ConnectionState().NegotiatedProtocol
+			return !isHttp2Protocol(protocol), expected, &ResultNegotiatedProtocol{protocol}
+		},
+	))
+
+	tg.AddTestGroup(Tls12FeaturesTestGroup(ctx))
+	tg.AddTestGroup(Tls12CipherSuitesTestGroup(ctx))
+
+	return tg
+}
+
+func Tls12FeaturesTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("9.2.1", "TLS 1.2 Features")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a ClientHello offering TLS compression",
+		"The endpoint MUST disable TLS compression.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultTlsCompression{0},
+				&ResultHandshakeFailure{},
+			}
+
+			// crypto/tls never offers compression, so the ClientHello
+			// is written by hand.  DEFLATE is offered before null.
+			method, err := sendClientHello(ctx, []byte{1, 0})
+			if err == nil {
+				return method == 0, expected, &ResultTlsCompression{method}
+			}
+			if _, ok := err.(tlsAlert); !ok {
+				return false, expected, &ResultError{err}
+			}
+
+			// the alert is a refusal of compression only if the same
+			// ClientHello without DEFLATE is accepted.
+			_, nullErr := sendClientHello(ctx, []byte{0})
+			if nullErr != nil {
+				return false, expected, &ResultError{fmt.Errorf("The ClientHello was refused without compression too (%v)", nullErr)}
+			}
+
+			return true, expected, &ResultHandshakeFailure{err}
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a ClientHello starting a renegotiation after the connection preface",
+		"The endpoint MUST treat a TLS renegotiation as a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			expected = []Result{
+				&ResultTlsRenegotiation{},
+				&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeProtocol},
+				&ResultConnectionClose{},
+			}
+
+			tlsConn, err := dialTls12(ctx)
+			if err != nil {
+				if _, ok := err.(tlsAlert); ok {
+					return true, nil, &ResultSkipped{fmt.Sprintf("The endpoint refused a TLS 1.2 handshake with AES-128-GCM (%v).", err)}
+				}
+				return false, expected, &ResultError{err}
+			}
+			defer tlsConn.Close()
+
+			if !isHttp2Protocol(tlsConn.protocol) {
+				return true, nil, &ResultSkipped{"HTTP/2 was not negotiated over TLS 1.2."}
+			}
+
+			conn := ctx.capture(tlsConn, false)
+			if ctx.testCase != nil && ctx.testCase.trace != nil {
+				conn = ctx.testCase.trace.traceConn(conn, false)
+			}
+
+			fmt.Fprintf(conn, clientPreface)
+			http2Conn := newHttp2Conn(conn, map[http2.SettingID]uint32{})
+			if ctx.testCase != nil {
+				ctx.testCase.addConn(http2Conn)
+			}
+			http2Conn.fr.WriteSettings()
+
+			err = tlsConn.renegotiate(tlsServerName(ctx))
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+
+			pass, _, actual = http2Conn.Expect(ctx,
+				ExpectGoAway(http2.ErrCodeProtocol),
+				ExpectConnectionClose(),
+			)
+
+			// the TLS layer reports a refusal with an alert, and the
+			// ServerHello of an accepted renegotiation.
+			if re, ok := actual.(*ResultError); ok {
+				var alert tlsAlert
+				if errors.As(re.Error, &alert) {
+					return true, expected, &ResultTlsRenegotiation{false, alert}
+				}
+				if errors.Is(re.Error, errRenegotiationAccepted) {
+					return false, expected, &ResultTlsRenegotiation{true, nil}
+				}
+			}
+
+			return pass, expected, actual
+		},
+	))
+
+	return tg
+}
+
+func Tls12CipherSuitesTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("9.2.2", "TLS 1.2 Cipher Suites")
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends a TLS 1.2 ClientHello offering only cipher suites in the black list",
+		"The endpoint SHOULD refuse the handshake, not negotiate HTTP/2, or respond with a connection error of type INADEQUATE_SECURITY.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testInadequateSecurity(ctx, func(config *tls.Config) {
+				config.MaxVersion = tls.VersionTLS12
+				config.CipherSuites = blackListedCipherSuites
+			})
+		},
+	))
+
+	return tg
+}
+
+// blackListedCipherSuites are the cipher suites of the black list in
+// RFC 7540, Appendix A which crypto/tls implements.
+var blackListedCipherSuites = []uint16{
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+}
+
+// handshakeTls connects to the target server over TLS with the
+// configuration modified by configure.
+func handshakeTls(ctx *Context, configure func(config *tls.Config)) (*tls.Conn, error) {
+	config := tlsConfig(ctx)
+	configure(config)
+	if config.ServerName == "" {
+		config.ServerName = ctx.Host
+	}
+
+	conn, err := net.DialTimeout("tcp", ctx.Authority(), ctx.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	// the port is recorded before the handshake, so that a refused
+	// handshake can be found in a capture too.
+	if ctx.testCase != nil {
+		ctx.testCase.addPort(conn.LocalAddr())
+	}
+
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(ctx.Timeout))
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+// isTlsAlert returns true if err tells that the endpoint refused the
+// handshake with a TLS alert.  Errors detected by h2spec itself, such
+// as a certificate which fails verification, are not a refusal.
+func isTlsAlert(err error) bool {
+	var alertErr tls.AlertError
+	if errors.As(err, &alertErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
+
+// isHttp2Protocol returns true if protocol is an ALPN identifier of
+// HTTP/2, including the drafts.
+func isHttp2Protocol(protocol string) bool {
+	return protocol == "h2" || strings.HasPrefix(protocol, "h2-")
+}
+
+// testInadequateSecurity connects with a TLS configuration which does
+// not meet the requirements of HTTP/2.  The endpoint passes if it
+// refuses the handshake, does not select HTTP/2, or sends a GOAWAY frame
+// with INADEQUATE_SECURITY.
+func testInadequateSecurity(ctx *Context, configure func(config *tls.Config)) (pass bool, expected []Result, actual Result) {
+	expected = []Result{
+		&ResultHandshakeFailure{},
+		&ResultNegotiatedProtocol{},
+		&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeInadequateSecurity},
+	}
+
+	tlsConn, err := handshakeTls(ctx, configure)
+	if err != nil {
+		if !isTlsAlert(err) {
+			return false, expected, &ResultError{err}
+		}
+		return true, expected, &ResultHandshakeFailure{err}
+	}
+	defer tlsConn.Close()
+
+	protocol := tlsConn.ConnectionState().NegotiatedProtocol
+	if !isHttp2Protocol(protocol) {
+		return true, expected, &ResultNegotiatedProtocol{protocol}
+	}
+
+	conn := ctx.capture(tlsConn, false)
+	if ctx.testCase != nil && ctx.testCase.trace != nil {
+		conn = ctx.testCase.trace.traceConn(conn, false)
+	}
+
+	fmt.Fprintf(conn, clientPreface)
+	http2Conn := newHttp2Conn(conn, map[http2.SettingID]uint32{})
+	if ctx.testCase != nil {
+		ctx.testCase.addConn(http2Conn)
+	}
+	http2Conn.fr.WriteSettings()
+
+	pass, _, actual = http2Conn.Expect(ctx, ExpectGoAway(http2.ErrCodeInadequateSecurity))
+	return pass, expected, actual
+}
+
+// tlsServerName returns the server name to send in the SNI extension,
+// which is empty for an IP address.
+func tlsServerName(ctx *Context) string {
+	if ctx.TlsConfig != nil && ctx.TlsConfig.ServerName != "" {
+		return ctx.TlsConfig.ServerName
+	}
+	if net.ParseIP(ctx.Host) != nil {
+		return ""
+	}
+	return ctx.Host
+}
+
+// clientHello returns a TLS 1.2 ClientHello record offering h2 with
+// ALPN and the compression methods.
+func clientHello(serverName string, compressionMethods []byte) []byte {
+	random := make([]byte, 32)
+	rand.Read(random)
+
+	cipherSuites := []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+	msg := clientHelloMessage(serverName, random, cipherSuites, compressionMethods, nil)
+
+	record := []byte{22, 0x03, 0x01}
+	record = appendUint16(record, uint16(len(msg)))
+	return append(record, msg...)
+}
+
+// clientHelloMessage returns a TLS 1.2 ClientHello handshake message
+// offering h2 with ALPN.  verifyData is the verify_data of the last
+// Finished message sent by the client, which is empty for the initial
+// handshake and identifies the connection for a renegotiation.
+func clientHelloMessage(serverName string, random []byte, cipherSuites []uint16, compressionMethods []byte, verifyData []byte) []byte {
+	var body []byte
+	body = append(body, 0x03, 0x03) // TLS 1.2
+	body = append(body, random...)
+	body = append(body, 0) // no session ID
+
+	body = appendUint16(body, uint16(2*len(cipherSuites)))
+	for _, cs := range cipherSuites {
+		body = appendUint16(body, cs)
+	}
+
+	body = append(body, byte(len(compressionMethods)))
+	body = append(body, compressionMethods...)
+
+	var exts []byte
+	if serverName != "" {
+		var sni []byte
+		sni = append(sni, 0) // host_name
+		sni = appendUint16(sni, uint16(len(serverName)))
+		sni = append(sni, serverName...)
+		exts = appendExtension(exts, 0x0000, appendUint16(nil, uint16(len(sni)), sni...))
+	}
+	// supported_groups: x25519, secp256r1, secp384r1
+	exts = appendExtension(exts, 0x000a, []byte{0x00, 0x06, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18})
+	// ec_point_formats: uncompressed
+	exts = appendExtension(exts, 0x000b, []byte{0x01, 0x00})
+	// signature_algorithms
+	exts = appendExtension(exts, 0x000d, []byte{
+		0x00, 0x10,
+		0x04, 0x03, 0x05, 0x03, 0x06, 0x03, 0x08, 0x04,
+		0x08, 0x05, 0x08, 0x06, 0x04, 0x01, 0x05, 0x01,
+	})
+	// application_layer_protocol_negotiation: h2
+	exts = appendExtension(exts, 0x0010, []byte{0x00, 0x03, 0x02, 'h', '2'})
+	// extended_master_secret
+	exts = appendExtension(exts, 0x0017, nil)
+	// renegotiation_info
+	exts = appendExtension(exts, 0xff01, append([]byte{byte(len(verifyData))}, verifyData...))
+	body = appendUint16(body, uint16(len(exts)))
+	body = append(body, exts...)
+
+	msg := []byte{1, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(msg, body...)
+}
+
+func appendUint16(b []byte, v uint16, data ...byte) []byte {
+	return append(append(b, byte(v>>8), byte(v)), data...)
+}
+
+func appendExtension(b []byte, typ uint16, data []byte) []byte {
+	b = appendUint16(b, typ)
+	b = appendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// tlsAlert is a TLS alert received on a connection whose TLS records
+// h2spec handles itself.
+type tlsAlert byte
+
+func (a tlsAlert) Error() string {
+	return fmt.Sprintf("TLS alert %d", byte(a))
+}
+
+// sendClientHello opens a new connection, sends the ClientHello
+// offering compressionMethods, and returns the compression method
+// selected by the endpoint.
+func sendClientHello(ctx *Context, compressionMethods []byte) (uint8, error) {
+	conn, err := net.DialTimeout("tcp", ctx.Authority(), ctx.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if ctx.testCase != nil {
+		ctx.testCase.addPort(conn.LocalAddr())
+	}
+
+	_, err = conn.Write(clientHello(tlsServerName(ctx), compressionMethods))
+	if err != nil {
+		return 0, err
+	}
+
+	return readServerHelloCompression(ctx, conn)
+}
+
+// readServerHelloCompression reads the ServerHello from conn and
+// returns the compression method selected by the server.
+func readServerHelloCompression(ctx *Context, conn net.Conn) (uint8, error) {
+	conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+
+	header := make([]byte, 5)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return 0, err
+	}
+	record := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	_, err = io.ReadFull(conn, record)
+	if err != nil {
+		return 0, err
+	}
+
+	switch header[0] {
+	case 21:
+		if len(record) < 2 {
+			return 0, fmt.Errorf("Malformed TLS alert")
+		}
+		return 0, tlsAlert(record[1])
+	case 22:
+	default:
+		return 0, fmt.Errorf("Unexpected TLS record of type %d", header[0])
+	}
+
+	// handshake type and length, version, random and session ID.
+	if len(record) < 39 || record[0] != 2 {
+		return 0, fmt.Errorf("Malformed ServerHello")
+	}
+	offset := 39 + int(record[38])
+	// cipher suite and compression method.
+	if len(record) < offset+3 {
+		return 0, fmt.Errorf("Malformed ServerHello")
+	}
+	return record[offset+2], nil
+}
@@ -0,0 +1,293 @@
+package h2spec
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// clientListener accepts the connections of the client under test in
+// client mode.  Connections are accepted in the background so that a
+// client connecting between two test cases is handed to the next one.
+type clientListener struct {
+	ln    net.Listener
+	conns chan net.Conn
+}
+
+func listenClient(ctx *Context) (*clientListener, error) {
+	ln, err := net.Listen("tcp", ctx.Authority())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %s (%v)", ctx.Authority(), err)
+	}
+
+	if ctx.Tls {
+		var config *tls.Config
+		if ctx.TlsConfig == nil {
+			config = new(tls.Config)
+		} else {
+			config = ctx.TlsConfig.Clone()
+		}
+
+		if len(config.Certificates) == 0 {
+			cert, err := selfSignedCertificate(ctx.Host)
+			if err != nil {
+				ln.Close()
+				return nil, err
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+
+		if config.NextProtos == nil {
+			config.NextProtos = []string{"h2"}
+		}
+
+		ln = tls.NewListener(ln, config)
+	}
+
+	cl := &clientListener{
+		ln:    ln,
+		conns: make(chan net.Conn),
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(cl.conns)
+				return
+			}
+			cl.conns <- conn
+		}
+	}()
+
+	return cl, nil
+}
+
+// Close stops accepting connections.  A connection waiting for a test
+// case is closed.
+func (cl *clientListener) Close() error {
+	err := cl.ln.Close()
+	for conn := range cl.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// selfSignedCertificate generates a certificate for host which is
+// valid for one day.
+func selfSignedCertificate(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "h2spec"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// AcceptHttp2Conn waits for the client under test to connect and reads
+// its connection preface.  If sn is true, it also exchanges SETTINGS
+// frames and waits for the first request of the client; the stream
+// identifier of that request is kept in Http2Conn.RequestStreamID.
+func AcceptHttp2Conn(ctx *Context, sn bool) (*Http2Conn, error) {
+	if ctx.listener == nil {
+		return nil, fmt.Errorf("h2spec is not running in client mode")
+	}
+
+	var conn net.Conn
+	var ok bool
+	select {
+	case conn, ok = <-ctx.listener.conns:
+		if !ok {
+			return nil, fmt.Errorf("Unable to accept a connection from the client")
+		}
+	case <-time.After(ctx.Timeout):
+		return nil, fmt.Errorf("The client did not connect")
+	}
+
+	if ctx.testCase != nil {
+		ctx.testCase.addPort(conn.RemoteAddr())
+	}
+	conn = ctx.capture(conn, true)
+	if ctx.testCase != nil && ctx.testCase.trace != nil {
+		conn = ctx.testCase.trace.traceServerConn(conn)
+	}
+
+	conn.SetDeadline(time.Now().Add(ctx.Timeout))
+
+	if tlsConn, ok := tlsConnOf(conn); ok {
+		err := tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed (%v)", err)
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+			conn.Close()
+			return nil, fmt.Errorf("HTTP/2 protocol was not negotiated")
+		}
+	}
+
+	preface := make([]byte, len(clientPreface))
+	_, err := io.ReadFull(conn, preface)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Unable to read the connection preface (%v)", err)
+	}
+	if !bytes.Equal(preface, []byte(clientPreface)) {
+		conn.Close()
+		return nil, fmt.Errorf("The client sent an invalid connection preface")
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	settings := map[http2.SettingID]uint32{}
+	http2Conn := newHttp2Conn(conn, settings)
+	http2Conn.accepted()
+	fr := http2Conn.fr
+	if ctx.testCase != nil {
+		ctx.testCase.addConn(http2Conn)
+	}
+
+	if sn {
+		doneCh := make(chan uint32, 1)
+		errCh := make(chan error, 1)
+		fr.WriteSettings()
+
+		go func() {
+			local := false
+			remote := false
+			var streamID uint32
+
+			for {
+				f, err := fr.ReadFrame()
+				if err != nil {
+					errCh <- err
+					return
+				}
+				http2Conn.receive(f)
+
+				switch f := f.(type) {
+				case *http2.SettingsFrame:
+					if f.IsAck() {
+						local = true
+					} else {
+						f.ForeachSetting(func(setting http2.Setting) error {
+							settings[setting.ID] = setting.Val
+							return nil
+						})
+						fr.WriteSettingsAck()
+						remote = true
+					}
+				case *http2.HeadersFrame:
+					if streamID == 0 {
+						streamID = f.StreamID
+					}
+				}
+
+				if local && remote && streamID != 0 {
+					doneCh <- streamID
+					return
+				}
+			}
+		}()
+
+		select {
+		case streamID := <-doneCh:
+			http2Conn.RequestStreamID = streamID
+		case err := <-errCh:
+			conn.Close()
+			return nil, fmt.Errorf("HTTP/2 settings negotiation failed (%v)", err)
+		case <-time.After(ctx.Timeout):
+			conn.Close()
+			return nil, fmt.Errorf("HTTP/2 settings negotiation timeout")
+		}
+	}
+
+	return http2Conn, nil
+}
+
+// tlsConnOf returns the TLS connection underlying conn, if any.
+func tlsConnOf(conn net.Conn) (*tls.Conn, bool) {
+	if tc, ok := conn.(*tapConn); ok {
+		conn = tc.Conn
+	}
+	if pc, ok := conn.(*pcapConn); ok {
+		conn = pc.Conn
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	return tlsConn, ok
+}
+
+// RunClient runs the test groups for HTTP/2 clients.  h2spec listens
+// on the address described by ctx and each test case waits for the
+// client under test to connect, so the client should be run repeatedly
+// until all test cases are done.  Test cases are always run one by one.
+func RunClient(ctx *Context) (*Report, error) {
+	if ctx.Tls {
+		err := ctx.loadTlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		defer ctx.closeKeyLog()
+	}
+
+	cl, err := listenClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+
+	clientCtx := *ctx
+	clientCtx.listener = cl
+	clientCtx.Parallel = 1
+
+	groups := []*TestGroup{
+		ClientConnectionPrefaceTestGroup(&clientCtx),
+		ClientFrameSizeTestGroup(&clientCtx),
+		ClientDataTestGroup(&clientCtx),
+		ClientSettingsTestGroup(&clientCtx),
+		ClientPingTestGroup(&clientCtx),
+		ClientGoawayTestGroup(&clientCtx),
+		ClientWindowUpdateTestGroup(&clientCtx),
+		ClientHttpHeaderFieldsTestGroup(&clientCtx),
+		ClientServerPushTestGroup(&clientCtx),
+	}
+
+	return runTestGroups(&clientCtx, groups)
+}
@@ -0,0 +1,436 @@
+package h2spec
+
+import (
+	"fmt"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// The test groups in this file are run by RunClient.  h2spec plays the
+// role of the server and the expected results are those of the client
+// under test.
+
+func ClientConnectionPrefaceTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("3.5", "HTTP/2 Connection Preface")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends invalid connection preface",
+		"The endpoint MUST treat this as a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, false)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			// The server connection preface MUST be a SETTINGS frame.
+			data := [8]byte{'h', '2', 's', 'p', 'e', 'c'}
+			http2Conn.fr.WritePing(false, data)
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
+
+func ClientFrameSizeTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("4.2", "Frame Size")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends large size frame that exceeds the SETTINGS_MAX_FRAME_SIZE",
+		"The endpoint MUST send a FRAME_SIZE_ERROR error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.RequestStreamID
+			hdrs := []hpack.HeaderField{
+				pair(":status", "200"),
+			}
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			max_size, ok := http2Conn.Settings[http2.SettingMaxFrameSize]
+			if !ok {
+				max_size = 16384
+			}
+
+			http2Conn.fr.WriteData(streamID, true, []byte(dummyData(int(max_size)+1)))
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeFrameSize}
+			return TestStreamError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
+
+func ClientDataTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("6.1", "DATA")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a DATA frame with 0x0 stream identifier",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			http2Conn.fr.WriteData(0, true, []byte("test"))
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
+
+func ClientSettingsTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("6.5", "SETTINGS")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a SETTINGS frame",
+		"The endpoint MUST sends a SETTINGS frame with ACK.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			settings := http2.Setting{http2.SettingMaxConcurrentStreams, 100}
+			http2Conn.fr.WriteSettings(settings)
+
+			return http2Conn.Expect(ctx, ExpectSettingsAck())
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a SETTINGS frame that is not a zero-length with ACK flag",
+		"The endpoint MUST respond with a connection error of type FRAME_SIZE_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			fmt.Fprintf(http2Conn.conn, "\x00\x00\x01\x04\x01\x00\x00\x00\x00\x00")
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeFrameSize}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"SETTINGS_ENABLE_PUSH (0x2): Sends the value other than 0 or 1",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
+			fmt.Fprintf(http2Conn.conn, "\x00\x02\x00\x00\x00\x02")
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"SETTINGS_INITIAL_WINDOW_SIZE (0x4): Sends the value above the maximum flow control window size",
+		"The endpoint MUST respond with a connection error of type FLOW_CONTROL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
+			fmt.Fprintf(http2Conn.conn, "\x00\x04\x80\x00\x00\x00")
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeFlowControl}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"SETTINGS_MAX_FRAME_SIZE (0x5): Sends the value below the initial value",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			fmt.Fprintf(http2Conn.conn, "\x00\x00\x06\x04\x00\x00\x00\x00\x00")
+			fmt.Fprintf(http2Conn.conn, "\x00\x05\x00\x00\x3f\xff")
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
+
+func ClientPingTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("6.7", "PING")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a PING frame",
+		"The endpoint MUST sends a PING frame with ACK, with an identical payload.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			data := [8]byte{'h', '2', 's', 'p', 'e', 'c'}
+			http2Conn.fr.WritePing(false, data)
+
+			return http2Conn.Expect(ctx, ExpectPingAck(data))
+		},
+	))
+
+	return tg
+}
+
+func ClientGoawayTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("6.8", "GOAWAY")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a GOAWAY frame with the stream identifier that is not 0x0 during a response",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			hdrs := []hpack.HeaderField{
+				pair(":status", "200"),
+			}
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = http2Conn.RequestStreamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			fmt.Fprintf(http2Conn.conn, "\x00\x00\x08\x07\x00\x00\x00\x00\x03")
+			fmt.Fprintf(http2Conn.conn, "\x00\x00\x00\x00\x00\x00\x00\x00")
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
+
+func ClientWindowUpdateTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("6.9", "WINDOW_UPDATE")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a WINDOW_UPDATE frame with a flow control window increment of 0",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			http2Conn.fr.WriteWindowUpdate(0, 0)
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
+
+func ClientHttpHeaderFieldsTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("8.1.2", "HTTP Header Fields")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a response that contains the header field name in uppercase letters",
+		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := []hpack.HeaderField{
+				pair(":status", "200"),
+				pair("X-TEST", "test"),
+			}
+
+			return testClientMalformedResponse(ctx, hdrs)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a response that contains the pseudo-header field defined for request",
+		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := []hpack.HeaderField{
+				pair(":status", "200"),
+				pair(":path", "/"),
+			}
+
+			return testClientMalformedResponse(ctx, hdrs)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a response that omits the \":status\" pseudo-header field",
+		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := []hpack.HeaderField{
+				pair("content-type", "text/plain"),
+			}
+
+			return testClientMalformedResponse(ctx, hdrs)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a response that contains the connection-specific header field",
+		"The endpoint MUST respond with a stream error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			hdrs := []hpack.HeaderField{
+				pair(":status", "200"),
+				pair("connection", "keep-alive"),
+			}
+
+			return testClientMalformedResponse(ctx, hdrs)
+		},
+	))
+
+	return tg
+}
+
+// testClientMalformedResponse responds to the request of the client
+// with hdrs, which make a malformed response, and expects a stream
+// error of type PROTOCOL_ERROR.
+func testClientMalformedResponse(ctx *Context, hdrs []hpack.HeaderField) (pass bool, expected []Result, actual Result) {
+	http2Conn, err := AcceptHttp2Conn(ctx, true)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer http2Conn.conn.Close()
+
+	var hp http2.HeadersFrameParam
+	hp.StreamID = http2Conn.RequestStreamID
+	hp.EndStream = true
+	hp.EndHeaders = true
+	hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+	http2Conn.fr.WriteHeaders(hp)
+
+	actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+	return TestStreamError(ctx, http2Conn, actualCodes)
+}
+
+func ClientServerPushTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("8.2", "Server Push")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a PUSH_PROMISE frame with 0x0 stream identifier",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			hdrs := commonHeaderFields(ctx)
+
+			var pp http2.PushPromiseParam
+			pp.StreamID = 0
+			pp.PromiseID = 2
+			pp.EndHeaders = true
+			pp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WritePushPromise(pp)
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a PUSH_PROMISE frame that promises a stream with an odd identifier",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			hdrs := commonHeaderFields(ctx)
+
+			var pp http2.PushPromiseParam
+			pp.StreamID = http2Conn.RequestStreamID
+			pp.PromiseID = http2Conn.RequestStreamID + 2
+			pp.EndHeaders = true
+			pp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WritePushPromise(pp)
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a PUSH_PROMISE frame when SETTINGS_ENABLE_PUSH is 0",
+		"The endpoint MUST respond with a connection error of type PROTOCOL_ERROR.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := AcceptHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			enablePush, ok := http2Conn.Settings[http2.SettingEnablePush]
+			if !ok || enablePush != 0 {
+				return true, nil, &ResultSkipped{"The client does not disable server push."}
+			}
+
+			hdrs := commonHeaderFields(ctx)
+
+			var pp http2.PushPromiseParam
+			pp.StreamID = http2Conn.RequestStreamID
+			pp.PromiseID = 2
+			pp.EndHeaders = true
+			pp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WritePushPromise(pp)
+
+			actualCodes := []http2.ErrCode{http2.ErrCodeProtocol}
+			return TestConnectionError(ctx, http2Conn, actualCodes)
+		},
+	))
+
+	return tg
+}
@@ -25,35 +25,88 @@ func (s *sections) Set(v string) error {
 }
 
 func main() {
+	// "h2spec client" runs the test cases for HTTP/2 clients. In this
+	// mode h2spec listens on the host and port instead of connecting.
+	clientMode := len(os.Args) > 1 && os.Args[1] == "client"
+	args := os.Args[1:]
+	if clientMode {
+		args = os.Args[2:]
+	}
+
 	port := flag.Int("p", 0, "Target port.")
 	host := flag.String("h", "127.0.0.1", "Target host.")
+	path := flag.String("path", "/", "Path of a large resource requested by the flow control tests.")
 	useTls := flag.Bool("t", false, "Connect over TLS.")
 	insecureSkipVerify := flag.Bool("k", false, "Don't verify server's certificate.")
+	alpn := flag.String("alpn", "h2", "Comma-separated list of the protocols offered with ALPN.")
+	caCert := flag.String("cacert", "", "CA certificates to verify the server's certificate.")
+	cert := flag.String("cert", "", "Client certificate for mutual TLS.")
+	key := flag.String("key", "", "Private key of the client certificate.")
+	sni := flag.String("sni", "", "Server name sent with SNI instead of the target host.")
+	tlsMin := flag.String("tls-min", "", "Minimum TLS version (1.0, 1.1, 1.2 or 1.3).")
+	tlsMax := flag.String("tls-max", "", "Maximum TLS version (1.0, 1.1, 1.2 or 1.3).")
+	keyLog := flag.String("keylog", "", "Append the TLS secrets to the file, for Wireshark.")
+	pcap := flag.String("pcap", "", "Write a pcapng capture of the plaintext traffic to the file.")
+	upgrade := flag.Bool("u", false, "Connect with HTTP/1.1 Upgrade (h2c).")
 	timeout := flag.Int("o", 2, "Maximum time allowed for test.")
 	strict := flag.Bool("S", false, "Strict mode.")
 	junit := flag.String("j", "", "Create test report also in JUnit format.")
+	jsonReport := flag.String("json", "", "Create test report also in JSON format.")
+	verbose := flag.Bool("v", false, "Print frame traces of failed test cases.")
+	traceDir := flag.String("trace", "", "Write frame traces of test cases into the directory.")
+	parallel := flag.Int("P", 1, "Maximum number of test cases run in parallel.")
+	rapidResetStreams := flag.Int("rapid-reset-streams", h2spec.DefaultDosThresholds.RapidResetStreams, "Number of streams opened and reset by the rapid reset tests.")
+	continuationFrames := flag.Int("continuation-frames", h2spec.DefaultDosThresholds.ContinuationFrames, "Maximum number of CONTINUATION frames sent by the CONTINUATION flood tests.")
+	flood := flag.Bool("flood", false, "Run the control frame flood tests.")
+	floodFrames := flag.Int("flood-frames", h2spec.DefaultDosThresholds.FloodFrames, "Number of frames sent by the control frame flood tests.")
+	continuationOctets := flag.Int("continuation-octets", h2spec.DefaultDosThresholds.ContinuationOctets, "Maximum size of the header block sent by the CONTINUATION flood tests.")
+	scenarios := flag.String("scenario", "", "Run also the scenario files in the directory.")
 	version := flag.Bool("version", false, "Display version information and exit.")
 
 	var sectionFlag sections
 	flag.Var(&sectionFlag, "s", "Section number on which to run the test")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [client] [OPTIONS]\n\n", os.Args[0])
+		fmt.Println("Commands:")
+		fmt.Println("  client:    Listen on the host and port, and test the HTTP/2 client that connects.")
+		fmt.Println("")
 		fmt.Println("Options:")
 		fmt.Println("  -p:        Target port. (Default: 80 or 443)")
 		fmt.Println("  -h:        Target host. (Default: 127.0.0.1)")
+		fmt.Println("  --path:    Path of a large resource requested by the flow control tests. (Default: /)")
 		fmt.Println("  -t:        Connect over TLS. (Default: false)")
 		fmt.Println("  -k:        Don't verify server's certificate. (Default: false)")
+		fmt.Println("  --alpn:    Comma-separated list of the protocols offered with ALPN. (Default: h2)")
+		fmt.Println("  --cacert:  CA certificates (PEM) to verify the server's certificate.")
+		fmt.Println("  --cert:    Client certificate (PEM) for mutual TLS. In client mode, the certificate of h2spec.")
+		fmt.Println("  --key:     Private key (PEM) of the certificate given by --cert.")
+		fmt.Println("  --sni:     Server name sent with SNI. (Default: the target host)")
+		fmt.Println("  --tls-min: Minimum TLS version: 1.0, 1.1, 1.2 or 1.3.")
+		fmt.Println("  --tls-max: Maximum TLS version: 1.0, 1.1, 1.2 or 1.3.")
+		fmt.Println("  --keylog:  Appends the TLS secrets to specified file for Wireshark. (Default: $SSLKEYLOGFILE)")
+		fmt.Println("  --pcap:    Writes a pcapng capture of the plaintext traffic into specified file.")
+		fmt.Println("  -u:        Connect with HTTP/1.1 Upgrade instead of prior knowledge. (Default: false)")
 		fmt.Println("  -o:        Maximum time allowed for test. (Default: 2)")
-		fmt.Println("  -s:        Section number on which to run the test. (Example: -s 6.1 -s 6.2)")
+		fmt.Println("  -s:        Section number on which to run the test. (Example: -s 6.1 -s 6.2 -s dos/rapid-reset)")
 		fmt.Println("  -S:        Run the test cases marked as \"strict\".")
 		fmt.Println("  -j:        Creates report also in JUnit format into specified file.")
+		fmt.Println("  --json:    Creates report also in JSON format into specified file.")
+		fmt.Println("  -P:        Maximum number of test cases run in parallel. (Default: 1)")
+		fmt.Println("  -v:        Print frame traces of failed test cases. (Default: false)")
+		fmt.Println("  --trace:   Writes frame trace of each test case into specified directory.")
+		fmt.Println("  --rapid-reset-streams: Number of streams opened and reset by the rapid reset tests. (Default: 1000)")
+		fmt.Println("  --continuation-frames: Maximum number of CONTINUATION frames sent by the CONTINUATION flood tests. (Default: 10000)")
+		fmt.Println("  --continuation-octets: Maximum size of the header block sent by the CONTINUATION flood tests. (Default: 4194304)")
+		fmt.Println("  --flood:   Run the control frame flood tests. (Default: false)")
+		fmt.Println("  --flood-frames: Number of frames sent by the control frame flood tests. (Default: 10000)")
+		fmt.Println("  --scenario: Runs also the test scenario files (.yaml, .yml, .json) in specified directory.")
 		fmt.Println("  --version: Display version information and exit.")
 		fmt.Println("  --help:    Display this help and exit.")
 		os.Exit(1)
 	}
 
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	if *version {
 		fmt.Fprintf(os.Stderr, "h2spec %s\n", VERSION)
@@ -71,24 +124,61 @@ func main() {
 	var ctx h2spec.Context
 	ctx.Port = *port
 	ctx.Host = *host
+	ctx.Path = *path
 	ctx.Timeout = time.Duration(*timeout) * time.Second
 	ctx.Strict = *strict
 	ctx.Junit = *junit
+	ctx.Json = *jsonReport
+	ctx.Parallel = *parallel
+	ctx.Dos.RapidResetStreams = *rapidResetStreams
+	ctx.Dos.ContinuationFrames = *continuationFrames
+	ctx.Dos.ContinuationOctets = *continuationOctets
+	ctx.Dos.FloodFrames = *floodFrames
+	ctx.Flood = *flood
+	ctx.Scenarios = *scenarios
+	ctx.Verbose = *verbose
+	ctx.TraceDir = *traceDir
 	ctx.Tls = *useTls
+	ctx.Upgrade = *upgrade
 	ctx.TlsConfig = &tls.Config{
 		InsecureSkipVerify: *insecureSkipVerify,
 	}
+	ctx.Alpn = strings.Split(*alpn, ",")
+	ctx.CACert = *caCert
+	ctx.Cert = *cert
+	ctx.Key = *key
+	ctx.SNI = *sni
+	ctx.KeyLog = *keyLog
+	ctx.Pcap = *pcap
+
+	var err error
+	ctx.MinTls, err = parseTlsVersion(*tlsMin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	ctx.MaxTls, err = parseTlsVersion(*tlsMax)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
 
 	if len(sectionFlag) > 0 {
 		ctx.Sections = map[string]bool{}
 		for _, sec := range sectionFlag {
+			// the named group of a section like dos/rapid-reset is
+			// shown with it.
+			if i := strings.Index(sec, "/"); i > 0 && !ctx.Sections[sec[:i]] {
+				ctx.Sections[sec[:i]] = false
+			}
+
 			splitedSec := strings.Split(sec, ".")
 			lastIndex := len(splitedSec) - 1
 
 			num := []string{}
 			for i, sec := range splitedSec {
 				num = append(num, sec)
-				if i != 0 {
+				if i != 0 || lastIndex == 0 {
 					key := strings.Join(num, ".")
 
 					runAll := false
@@ -102,7 +192,38 @@ func main() {
 		}
 	}
 
-	if !h2spec.Run(&ctx) {
+	var report *h2spec.Report
+	if clientMode {
+		report, err = h2spec.RunClient(&ctx)
+	} else {
+		report, err = h2spec.Run(&ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if !report.Pass() {
 		os.Exit(1)
 	}
 }
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTlsVersion returns the TLS version named by v, or 0 if v is
+// empty.
+func parseTlsVersion(v string) (uint16, error) {
+	if v == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[v]
+	if !ok {
+		return 0, fmt.Errorf("Unknown TLS version: %s", v)
+	}
+	return version, nil
+}
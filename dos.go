@@ -0,0 +1,512 @@
+package h2spec
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// DosThresholds holds the limits used by the DoS resilience test
+// cases.  Zero values are replaced by DefaultDosThresholds.
+type DosThresholds struct {
+	RapidResetStreams  int // the number of streams opened and reset
+	ContinuationFrames int // the maximum number of CONTINUATION frames
+	ContinuationOctets int // the maximum size of a header block
+	FloodFrames        int // the number of frames sent by control frame floods
+}
+
+var DefaultDosThresholds = DosThresholds{
+	RapidResetStreams:  1000,
+	ContinuationFrames: 10000,
+	ContinuationOctets: 4 << 20,
+	FloodFrames:        10000,
+}
+
+func (dt DosThresholds) rapidResetStreams() int {
+	if dt.RapidResetStreams > 0 {
+		return dt.RapidResetStreams
+	}
+	return DefaultDosThresholds.RapidResetStreams
+}
+
+func (dt DosThresholds) continuationFrames() int {
+	if dt.ContinuationFrames > 0 {
+		return dt.ContinuationFrames
+	}
+	return DefaultDosThresholds.ContinuationFrames
+}
+
+func (dt DosThresholds) floodFrames() int {
+	if dt.FloodFrames > 0 {
+		return dt.FloodFrames
+	}
+	return DefaultDosThresholds.FloodFrames
+}
+
+func (dt DosThresholds) continuationOctets() int {
+	if dt.ContinuationOctets > 0 {
+		return dt.ContinuationOctets
+	}
+	return DefaultDosThresholds.ContinuationOctets
+}
+
+// flooder sends a flood of frames without waiting for the responses
+// of the target, and reports how the target reacted.  The target
+// reacts by sending a frame for which react returns true, by closing
+// the connection or, if it stops reading, by back-pressure.
+type flooder struct {
+	// send writes the i-th batch of frames and returns the number of
+	// frames and octets it wrote.
+	send  func(i int) (int, int, error)
+	react func(f http2.Frame) bool
+
+	// ping makes the flooder send a PING frame once all frames have
+	// been sent, telling whether the target processed them all
+	// without reacting.  Otherwise, the target is given the timeout
+	// to react.
+	ping bool
+
+	// deferRead makes the flooder leave the frames sent by the target
+	// unread until all frames have been sent, like an attacker which
+	// does not read responses.
+	deferRead bool
+}
+
+// run calls fl.send up to n times.  http2Conn must not be read after
+// run returns, since the frames may still be read in the background
+// until the timeout.
+func (fl *flooder) run(ctx *Context, http2Conn *Http2Conn, n int) *ResultFlood {
+	reactionCh := make(chan Result, 1)
+	pongCh := make(chan bool, 1)
+	pingData := [8]byte{'h', '2', 's', 'p', 'e', 'c', 'f', 'l'}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	reading := false
+	read := func() {
+		if reading {
+			return
+		}
+		reading = true
+
+		go func() {
+			for {
+				f, err := http2Conn.ReadFrame(ctx.Timeout)
+				if err == TIMEOUT {
+					select {
+					case <-done:
+						return
+					default:
+						continue
+					}
+				} else if err != nil {
+					if err == io.EOF || errors.Is(err, syscall.ECONNRESET) {
+						reactionCh <- &ResultConnectionClose{}
+					} else {
+						reactionCh <- &ResultError{err}
+					}
+					return
+				}
+
+				if pf, ok := f.(*http2.PingFrame); ok && pf.IsAck() && pf.Data == pingData {
+					pongCh <- true
+				}
+				if fl.react(f) {
+					reactionCh <- CreateResultFrame(f)
+					return
+				}
+			}
+		}()
+	}
+
+	if !fl.deferRead {
+		read()
+	}
+
+	result := &ResultFlood{}
+	start := time.Now()
+	defer func() {
+		result.Elapsed = time.Since(start)
+	}()
+
+	// write errors other than timeouts are caused by a closed
+	// connection, whose reason the reader will tell.
+	write := func(send func() (int, int, error)) bool {
+		http2Conn.conn.SetWriteDeadline(time.Now().Add(ctx.Timeout))
+		frames, octets, err := send()
+		if err == nil {
+			result.Frames += frames
+			result.Octets += octets
+			return true
+		}
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			result.Reaction = &ResultBackPressure{}
+			return false
+		}
+
+		read()
+		select {
+		case result.Reaction = <-reactionCh:
+		case <-time.After(ctx.Timeout):
+			result.Reaction = &ResultConnectionClose{}
+		}
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case result.Reaction = <-reactionCh:
+			return result
+		default:
+		}
+
+		if !write(func() (int, int, error) { return fl.send(i) }) {
+			return result
+		}
+	}
+
+	if fl.ping {
+		ok := write(func() (int, int, error) {
+			return 1, 9 + 8, http2Conn.fr.WritePing(false, pingData)
+		})
+		if !ok {
+			return result
+		}
+	}
+
+	read()
+	select {
+	case result.Reaction = <-reactionCh:
+	case <-pongCh:
+	case <-time.After(ctx.Timeout):
+	}
+
+	return result
+}
+
+// floodBounded returns true if reaction shows that the target bounded
+// the work caused by a flood: closing the connection with a GOAWAY frame
+// with one of codes, closing it without a GOAWAY frame, refusing or
+// responding to streams, or stopping to read.  A GOAWAY frame with
+// NO_ERROR is a graceful shutdown, which lets the streams already
+// opened run to completion, so it does not bound the work.
+func floodBounded(reaction Result, codes []http2.ErrCode) bool {
+	switch r := reaction.(type) {
+	case *ResultFrame:
+		if r.Type == http2.FrameGoAway {
+			return TestErrorCode(r.ErrCode, codes)
+		}
+		return r.Type == http2.FrameRSTStream || r.Type == http2.FrameHeaders
+	case *ResultConnectionClose, *ResultBackPressure:
+		return true
+	}
+
+	return false
+}
+
+func DosResilienceTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("dos", "DoS Resilience")
+
+	tg.AddTestGroup(RapidResetTestGroup(ctx))
+	tg.AddTestGroup(ContinuationFloodTestGroup(ctx))
+	if ctx.Flood {
+		tg.AddTestGroup(ControlFrameFloodTestGroup(ctx))
+	}
+
+	return tg
+}
+
+func RapidResetTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("dos/rapid-reset", "Rapid Reset (CVE-2023-44487)")
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends HEADERS frames with END_STREAM flag each followed by a RST_STREAM frame",
+		"The endpoint should close the connection with ENHANCE_YOUR_CALM, or refuse the streams. A graceful GOAWAY frame with NO_ERROR is not enough.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testRapidReset(ctx, true)
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends HEADERS frames without END_STREAM flag each followed by a RST_STREAM frame",
+		"The endpoint should close the connection with ENHANCE_YOUR_CALM, or refuse the streams. A graceful GOAWAY frame with NO_ERROR is not enough.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testRapidReset(ctx, false)
+		},
+	))
+
+	return tg
+}
+
+// testRapidReset opens streams and cancels them right away, without
+// waiting for the responses.  endStream tells whether the requests are
+// complete, leaving the streams half-closed (remote) when reset, or
+// leaves them open.
+func testRapidReset(ctx *Context, endStream bool) (pass bool, expected []Result, actual Result) {
+	codes := []http2.ErrCode{http2.ErrCodeEnhanceYourCalm, http2.ErrCodeProtocol}
+	expected = []Result{
+		&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeEnhanceYourCalm},
+		&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeProtocol},
+		&ResultFrame{LengthDefault, http2.FrameRSTStream, FlagDefault, http2.ErrCodeRefusedStream},
+		&ResultConnectionClose{},
+	}
+
+	http2Conn, err := CreateHttp2Conn(ctx, true)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer http2Conn.conn.Close()
+
+	hdrs := commonHeaderFields(ctx)
+	if !endStream {
+		hdrs[0].Value = "POST"
+	}
+
+	send := func(i int) (int, int, error) {
+		streamID := http2Conn.NextStreamID()
+
+		var hp http2.HeadersFrameParam
+		hp.StreamID = streamID
+		hp.EndStream = endStream
+		hp.EndHeaders = true
+		hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+		if err := http2Conn.fr.WriteHeaders(hp); err != nil {
+			return 0, 0, err
+		}
+
+		if err := http2Conn.fr.WriteRSTStream(streamID, http2.ErrCodeCancel); err != nil {
+			return 1, 9 + len(hp.BlockFragment), err
+		}
+
+		return 2, 9 + len(hp.BlockFragment) + 9 + 4, nil
+	}
+
+	react := func(f http2.Frame) bool {
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return true
+		case *http2.RSTStreamFrame:
+			return f.ErrCode == http2.ErrCodeRefusedStream || f.ErrCode == http2.ErrCodeEnhanceYourCalm
+		}
+		return false
+	}
+
+	fl := &flooder{send: send, react: react, ping: true}
+	result := fl.run(ctx, http2Conn, ctx.Dos.rapidResetStreams())
+	return floodBounded(result.Reaction, codes), expected, result
+}
+
+func ContinuationFloodTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("dos/continuation", "CONTINUATION Flood")
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends a HEADERS frame followed by empty CONTINUATION frames without END_HEADERS flag",
+		"The endpoint should close the connection with an error, or respond with 431 status code or a RST_STREAM frame. A graceful GOAWAY frame with NO_ERROR is not enough.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			return testContinuationFlood(ctx, nil)
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends a HEADERS frame followed by CONTINUATION frames with header fields without END_HEADERS flag",
+		"The endpoint should close the connection with an error, or respond with 431 status code or a RST_STREAM frame. A graceful GOAWAY frame with NO_ERROR is not enough.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			field := new(hpackBlock).LiteralWithoutIndexing(0, "x-dummy", dummyData(4000))
+			return testContinuationFlood(ctx, field.Bytes())
+		},
+	))
+
+	return tg
+}
+
+// testContinuationFlood starts a request with a HEADERS frame and sends
+// CONTINUATION frames carrying fragment without ever ending the header
+// block, until the frame or octet budget of ctx.Dos is exhausted.
+func testContinuationFlood(ctx *Context, fragment []byte) (pass bool, expected []Result, actual Result) {
+	codes := []http2.ErrCode{http2.ErrCodeEnhanceYourCalm, http2.ErrCodeProtocol}
+	expected = []Result{
+		&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeEnhanceYourCalm},
+		&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeProtocol},
+		&ResultFrame{LengthDefault, http2.FrameHeaders, FlagDefault, ErrCodeDefault},
+		&ResultFrame{LengthDefault, http2.FrameRSTStream, FlagDefault, ErrCodeDefault},
+		&ResultConnectionClose{},
+	}
+
+	http2Conn, err := CreateHttp2Conn(ctx, true)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer http2Conn.conn.Close()
+
+	streamID := http2Conn.NextStreamID()
+
+	n := ctx.Dos.continuationFrames()
+	if len(fragment) > 0 {
+		if max := ctx.Dos.continuationOctets() / (9 + len(fragment)); max < n {
+			n = max
+		}
+	}
+
+	send := func(i int) (int, int, error) {
+		if i == 0 {
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = false
+			hp.BlockFragment = http2Conn.EncodeHeader(commonHeaderFields(ctx))
+			err := http2Conn.fr.WriteHeaders(hp)
+			return 1, 9 + len(hp.BlockFragment), err
+		}
+
+		err := http2Conn.fr.WriteContinuation(streamID, false, fragment)
+		return 1, 9 + len(fragment), err
+	}
+
+	react := func(f http2.Frame) bool {
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return true
+		case *http2.RSTStreamFrame:
+			return f.StreamID == streamID
+		case *http2.HeadersFrame:
+			return f.StreamID == streamID
+		}
+		return false
+	}
+
+	fl := &flooder{send: send, react: react}
+	result := fl.run(ctx, http2Conn, n+1)
+	return floodBounded(result.Reaction, codes), expected, result
+}
+
+func ControlFrameFloodTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("dos/control-flood", "Control Frame Flood")
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends PING frames without reading the PING frames with ACK flag",
+		"The endpoint should close the connection with ENHANCE_YOUR_CALM, or stop reading.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			send := func(i int) (int, int, error) {
+				data := [8]byte{'h', '2', 's', 'p', 'e', 'c'}
+				data[6] = byte(i >> 8)
+				data[7] = byte(i)
+				return 1, 9 + 8, http2Conn.fr.WritePing(false, data)
+			}
+
+			fl := &flooder{send: send, react: isGoAway, ping: true, deferRead: true}
+			return testControlFrameFlood(ctx, http2Conn, fl)
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends SETTINGS frames without reading the SETTINGS frames with ACK flag",
+		"The endpoint should close the connection with ENHANCE_YOUR_CALM, or stop reading.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			send := func(i int) (int, int, error) {
+				return 1, 9, http2Conn.fr.WriteSettings()
+			}
+
+			fl := &flooder{send: send, react: isGoAway, ping: true, deferRead: true}
+			return testControlFrameFlood(ctx, http2Conn, fl)
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends DATA frames with zero length on a stream",
+		"The endpoint should close the connection with ENHANCE_YOUR_CALM, or reset the stream.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			hdrs := commonHeaderFields(ctx)
+			hdrs[0].Value = "POST"
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = false
+			hp.EndHeaders = true
+			hp.BlockFragment = http2Conn.EncodeHeader(hdrs)
+			http2Conn.fr.WriteHeaders(hp)
+
+			send := func(i int) (int, int, error) {
+				return 1, 9, http2Conn.fr.WriteData(streamID, false, []byte{})
+			}
+
+			react := func(f http2.Frame) bool {
+				_, ok := f.(*http2.RSTStreamFrame)
+				return ok || isGoAway(f)
+			}
+
+			fl := &flooder{send: send, react: react, ping: true}
+			return testControlFrameFlood(ctx, http2Conn, fl)
+		},
+	))
+
+	tg.AddTestCase(NewAdvisoryTestCase(
+		"Sends PRIORITY frames on many streams",
+		"The endpoint should close the connection with ENHANCE_YOUR_CALM, or stop reading.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			// each PRIORITY frame makes an idle stream depend on
+			// the previous one.
+			send := func(i int) (int, int, error) {
+				var pp http2.PriorityParam
+				pp.StreamDep = uint32(i*2 + 1)
+				pp.Weight = 255
+				return 1, 9 + 5, http2Conn.fr.WritePriority(uint32(i*2+3), pp)
+			}
+
+			fl := &flooder{send: send, react: isGoAway, ping: true}
+			return testControlFrameFlood(ctx, http2Conn, fl)
+		},
+	))
+
+	return tg
+}
+
+// testControlFrameFlood runs fl with the number of frames given by
+// ctx.Dos.
+func testControlFrameFlood(ctx *Context, http2Conn *Http2Conn, fl *flooder) (pass bool, expected []Result, actual Result) {
+	codes := []http2.ErrCode{http2.ErrCodeEnhanceYourCalm}
+	expected = []Result{
+		&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeEnhanceYourCalm},
+		&ResultConnectionClose{},
+		&ResultBackPressure{},
+	}
+
+	result := fl.run(ctx, http2Conn, ctx.Dos.floodFrames())
+	return floodBounded(result.Reaction, codes), expected, result
+}
+
+func isGoAway(f http2.Frame) bool {
+	_, ok := f.(*http2.GoAwayFrame)
+	return ok
+}
@@ -0,0 +1,197 @@
+package h2spec
+
+import (
+	"errors"
+	"io"
+	"syscall"
+
+	"golang.org/x/net/http2"
+)
+
+// Expectation describes a reaction of the endpoint which passes a test
+// case.  Expectations are given to Http2Conn.Expect.
+type Expectation struct {
+	Result  Result                   // how the expectation is reported
+	match   func(f http2.Frame) bool // nil unless met by a frame
+	fail    func(f http2.Frame) bool // true if f rules out the expectation
+	skip    func(f http2.Frame) bool // true if f is expected on the way
+	close   bool                     // met by the connection close
+	timeout bool                     // met by the timeout
+}
+
+// gaveUp returns true if f is a GOAWAY or RST_STREAM frame, which ends
+// the wait for any other frame.
+func gaveUp(f http2.Frame) bool {
+	switch f.(type) {
+	case *http2.GoAwayFrame, *http2.RSTStreamFrame:
+		return true
+	}
+	return false
+}
+
+// ExpectFrame expects a frame of type typ with flags set.  FlagDefault
+// accepts any flags.
+func ExpectFrame(typ http2.FrameType, flags http2.Flags) Expectation {
+	result := &ResultFrame{LengthDefault, typ, flags, ErrCodeDefault}
+	return ExpectFunc(typ, result, func(f http2.Frame) bool {
+		return flags == FlagDefault || f.Header().Flags.Has(flags)
+	})
+}
+
+// ExpectFunc expects a frame of type typ for which match returns true,
+// reported as result.
+func ExpectFunc(typ http2.FrameType, result Result, match func(f http2.Frame) bool) Expectation {
+	return Expectation{
+		Result: result,
+		match: func(f http2.Frame) bool {
+			return f.Header().Type == typ && match(f)
+		},
+		fail: gaveUp,
+	}
+}
+
+// ExpectSettingsAck expects a SETTINGS frame with ACK flag.
+func ExpectSettingsAck() Expectation {
+	return ExpectFrame(http2.FrameSettings, http2.FlagSettingsAck)
+}
+
+// ExpectPingAck expects a PING frame with ACK flag carrying data.
+func ExpectPingAck(data [8]byte) Expectation {
+	result := &ResultFrame{8, http2.FramePing, http2.FlagPingAck, ErrCodeDefault}
+	return ExpectFunc(http2.FramePing, result, func(f http2.Frame) bool {
+		pf := f.(*http2.PingFrame)
+		return pf.IsAck() && pf.Data == data
+	})
+}
+
+// ExpectGoAway expects a GOAWAY frame with the error code.  Other
+// frames, including RST_STREAM frames, are skipped.
+func ExpectGoAway(code http2.ErrCode) Expectation {
+	result := &ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, code}
+	exp := ExpectFunc(http2.FrameGoAway, result, func(f http2.Frame) bool {
+		return f.(*http2.GoAwayFrame).ErrCode == code
+	})
+	exp.fail = func(f http2.Frame) bool {
+		_, ok := f.(*http2.GoAwayFrame)
+		return ok
+	}
+	return exp
+}
+
+// ExpectRstStream expects a RST_STREAM frame with the error code.
+func ExpectRstStream(code http2.ErrCode) Expectation {
+	result := &ResultFrame{LengthDefault, http2.FrameRSTStream, FlagDefault, code}
+	return ExpectFunc(http2.FrameRSTStream, result, func(f http2.Frame) bool {
+		return f.(*http2.RSTStreamFrame).ErrCode == code
+	})
+}
+
+// ExpectStreamClose expects a HEADERS or DATA frame with END_STREAM
+// flag on the stream.  A streamID of 0 accepts any stream.  Other
+// frames are skipped.
+func ExpectStreamClose(streamID uint32) Expectation {
+	return Expectation{
+		Result: &ResultStreamClose{},
+		match: func(f http2.Frame) bool {
+			if streamID != 0 && f.Header().StreamID != streamID {
+				return false
+			}
+			switch f := f.(type) {
+			case *http2.HeadersFrame:
+				return f.StreamEnded()
+			case *http2.DataFrame:
+				return f.StreamEnded()
+			}
+			return false
+		},
+	}
+}
+
+// ExpectConnectionClose expects the endpoint to close the connection.
+// A connection close following a GOAWAY frame does not meet the
+// expectation, since the GOAWAY frame tells the reason.
+func ExpectConnectionClose() Expectation {
+	return Expectation{Result: &ResultConnectionClose{}, close: true}
+}
+
+// ExpectTimeout expects no frame and no connection close within the
+// timeout.  Any frame which does not meet another expectation rules it
+// out.
+func ExpectTimeout() Expectation {
+	return Expectation{
+		Result:  &ResultTestTimeout{},
+		fail:    func(f http2.Frame) bool { return true },
+		timeout: true,
+	}
+}
+
+// Expect reads frames until one of exps is met or ruled out, and
+// returns the result of the test case.  Frames which neither meet nor
+// rule out any expectation are skipped, and so are frames which one of
+// exps expects on the way, even if they rule out the others.  The last
+// frame read is reported as the actual result unless the test case
+// passed.
+func (h2Conn *Http2Conn) Expect(ctx *Context, exps ...Expectation) (pass bool, expected []Result, actual Result) {
+	for _, exp := range exps {
+		expected = append(expected, exp.Result)
+	}
+
+	for {
+		f, err := h2Conn.ReadFrame(ctx.Timeout)
+		if err != nil {
+			if err == io.EOF || errors.Is(err, syscall.ECONNRESET) {
+				rf, ok := actual.(*ResultFrame)
+				if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
+					actual = &ResultConnectionClose{}
+					for _, exp := range exps {
+						pass = pass || exp.close
+					}
+				}
+			} else if err == TIMEOUT {
+				if actual == nil {
+					actual = &ResultTestTimeout{}
+				}
+				for _, exp := range exps {
+					pass = pass || exp.timeout
+				}
+			} else {
+				actual = &ResultError{err}
+			}
+			return pass, expected, actual
+		}
+
+		for _, exp := range exps {
+			if exp.match != nil && exp.match(f) {
+				return true, expected, exp.actual(f)
+			}
+		}
+
+		actual = CreateResultFrame(f)
+		if skipped(f, exps) {
+			continue
+		}
+		for _, exp := range exps {
+			if exp.fail != nil && exp.fail(f) {
+				return false, expected, actual
+			}
+		}
+	}
+}
+
+// skipped returns true if one of exps expects f on the way.
+func skipped(f http2.Frame, exps []Expectation) bool {
+	for _, exp := range exps {
+		if exp.skip != nil && exp.skip(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// actual returns the actual result for f meeting the expectation.
+func (exp *Expectation) actual(f http2.Frame) Result {
+	if _, ok := exp.Result.(*ResultFrame); ok {
+		return CreateResultFrame(f)
+	}
+	return exp.Result
+}
@@ -0,0 +1,275 @@
+package h2spec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// flowControl tracks the flow-control windows of the peer, which limit
+// the DATA frames h2spec can send.  DATA frames sent consume the
+// windows and WINDOW_UPDATE frames received replenish them.
+type flowControl struct {
+	mu      sync.Mutex
+	initial int64 // SETTINGS_INITIAL_WINDOW_SIZE of the peer
+	conn    int64 // the window of the connection
+	streams map[uint32]int64
+	sent    *frameSplitter
+	overrun bool // a DATA frame exceeding a window was sent
+}
+
+func newFlowControl() *flowControl {
+	fc := &flowControl{
+		initial: 65535,
+		conn:    65535,
+		streams: map[uint32]int64{},
+	}
+	fc.sent = &frameSplitter{fn: fc.trackSent}
+	return fc
+}
+
+// window returns the window of streamID.
+func (fc *flowControl) window(streamID uint32) int64 {
+	if w, ok := fc.streams[streamID]; ok {
+		return w
+	}
+	return fc.initial
+}
+
+// available returns the number of octets which can be sent on streamID
+// now.
+func (fc *flowControl) available(streamID uint32) int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	n := fc.window(streamID)
+	if fc.conn < n {
+		n = fc.conn
+	}
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// windows returns the windows of the connection and streamID.
+func (fc *flowControl) windows(streamID uint32) (conn, stream int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.conn, fc.window(streamID)
+}
+
+// exceeded returns true if a DATA frame exceeding the window of the
+// connection or its stream was sent.
+func (fc *flowControl) exceeded() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.overrun
+}
+
+// Write takes the bytes written to the connection after the connection
+// preface.
+func (fc *flowControl) Write(p []byte) (int, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.sent.Write(p)
+}
+
+// trackSent consumes the windows with a DATA frame sent.  The whole
+// payload, including padding, is subject to flow control.
+func (fc *flowControl) trackSent(fh http2.FrameHeader, payload []byte) {
+	if fh.Type != http2.FrameData || fh.StreamID == 0 {
+		return
+	}
+
+	length := int64(fh.Length)
+	if length > fc.conn || length > fc.window(fh.StreamID) {
+		fc.overrun = true
+	}
+
+	fc.conn -= length
+	fc.streams[fh.StreamID] = fc.window(fh.StreamID) - length
+}
+
+// recv updates the windows with a frame received from the peer.
+func (fc *flowControl) recv(f http2.Frame) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	switch f := f.(type) {
+	case *http2.WindowUpdateFrame:
+		if f.StreamID == 0 {
+			fc.conn += int64(f.Increment)
+		} else {
+			fc.streams[f.StreamID] = fc.window(f.StreamID) + int64(f.Increment)
+		}
+	case *http2.SettingsFrame:
+		if f.IsAck() {
+			return
+		}
+		// a new initial window size adjusts the windows of all the
+		// streams, but not the window of the connection.
+		if v, ok := f.Value(http2.SettingInitialWindowSize); ok {
+			delta := int64(v) - fc.initial
+			for id := range fc.streams {
+				fc.streams[id] += delta
+			}
+			fc.initial = int64(v)
+		}
+	}
+}
+
+// recordFrame keeps a copy of the frame read last, so that it can be
+// parsed again once the framer reuses its buffer.
+func (h2Conn *Http2Conn) recordFrame(fh http2.FrameHeader, payload []byte) {
+	var header [9]byte
+	binary.BigEndian.PutUint32(header[:], fh.Length<<8)
+	header[3] = byte(fh.Type)
+	header[4] = byte(fh.Flags)
+	binary.BigEndian.PutUint32(header[5:], fh.StreamID)
+
+	h2Conn.lastFrame = append(append(h2Conn.lastFrame[:0], header[:]...), payload...)
+}
+
+// copyFrame returns a copy of the frame returned by the last ReadFrame
+// call, which remains valid after the next call.
+func (h2Conn *Http2Conn) copyFrame() (http2.Frame, error) {
+	fr := http2.NewFramer(nil, bytes.NewReader(h2Conn.lastFrame))
+	fr.AllowIllegalReads = true
+	fr.SetMaxReadFrameSize(1<<24 - 1)
+	return fr.ReadFrame()
+}
+
+// maxFrameSize returns SETTINGS_MAX_FRAME_SIZE of the peer.
+func (h2Conn *Http2Conn) maxFrameSize() int {
+	if v, ok := h2Conn.Settings[http2.SettingMaxFrameSize]; ok && v > 0 {
+		return int(v)
+	}
+	return 16384
+}
+
+// SendBody sends body on streamID as DATA frames of up to
+// SETTINGS_MAX_FRAME_SIZE of the peer.  When the flow-control window of
+// the stream or the connection is exhausted, SendBody reads frames
+// until WINDOW_UPDATE frames make room; the frames read meanwhile are
+// returned by later ReadFrame calls.  Sending stops without an error
+// once the peer resets the stream, sends a GOAWAY frame or closes the
+// connection, leaving the reaction to be checked by the test case.
+func (h2Conn *Http2Conn) SendBody(ctx *Context, streamID uint32, body []byte, endStream bool) error {
+	return h2Conn.SendBodyOverrun(ctx, streamID, body, endStream, 0)
+}
+
+// SendBodyOverrun is like SendBody, but the last overrun octets of body
+// exceed the flow-control window, which the peer must treat as a
+// FLOW_CONTROL_ERROR.  The window is synchronized with a PING frame
+// first, so that no window update in flight covers the overrun.
+func (h2Conn *Http2Conn) SendBodyOverrun(ctx *Context, streamID uint32, body []byte, endStream bool, overrun int) error {
+	maxFrameSize := h2Conn.maxFrameSize()
+	synced := false
+
+	for {
+		avail := h2Conn.flow.available(streamID)
+
+		n := avail
+		if overrun > 0 && len(body) <= avail+overrun {
+			if !synced {
+				synced = true
+				err := h2Conn.syncWindows(ctx)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			n = avail + overrun
+		}
+		if n > len(body) {
+			n = len(body)
+		}
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+
+		if n > 0 || len(body) == 0 {
+			last := n == len(body)
+			err := h2Conn.fr.WriteData(streamID, endStream && last, body[:n])
+			if err != nil {
+				return err
+			}
+			if n > avail {
+				overrun -= n - avail
+			}
+			if last {
+				return nil
+			}
+			body = body[n:]
+			continue
+		}
+
+		f, err := h2Conn.readBacklog(ctx)
+		if err == TIMEOUT {
+			return fmt.Errorf("Flow-control window of stream %d was not updated", streamID)
+		} else if err != nil {
+			return nil
+		}
+
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return nil
+		case *http2.RSTStreamFrame:
+			if f.StreamID == streamID {
+				return nil
+			}
+		}
+	}
+}
+
+// syncWindows sends a PING frame and reads frames until it is
+// acknowledged, so that the window updates sent by the peer before are
+// taken into account.  The frames read meanwhile are returned by later
+// ReadFrame calls.
+func (h2Conn *Http2Conn) syncWindows(ctx *Context) error {
+	data := [8]byte{'h', '2', 's', 'p', 'e', 'c', 'f', 'c'}
+	h2Conn.fr.WritePing(false, data)
+
+	for {
+		f, err := h2Conn.readBacklog(ctx)
+		if err == TIMEOUT {
+			return fmt.Errorf("PING frame was not acknowledged")
+		} else if err != nil {
+			return nil
+		}
+
+		switch f := f.(type) {
+		case *http2.PingFrame:
+			if f.IsAck() && f.Data == data {
+				h2Conn.backlog = h2Conn.backlog[:len(h2Conn.backlog)-1]
+				return nil
+			}
+		case *http2.GoAwayFrame:
+			return nil
+		}
+	}
+}
+
+// readBacklog reads a frame from the connection and keeps a copy of it
+// to be returned by a later ReadFrame call.
+func (h2Conn *Http2Conn) readBacklog(ctx *Context) (http2.Frame, error) {
+	f, err := h2Conn.readFrame(ctx.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	cf, err := h2Conn.copyFrame()
+	if err != nil {
+		return nil, err
+	}
+	h2Conn.backlog = append(h2Conn.backlog, cf)
+
+	return f, nil
+}
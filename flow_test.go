@@ -0,0 +1,158 @@
+package h2spec
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// serveTestConn accepts a single connection on a local port and runs
+// serve on it after the connection preface.  It returns a context which
+// connects to that port.
+func serveTestConn(t *testing.T, serve func(fr *http2.Framer)) *Context {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		preface := make([]byte, len(clientPreface))
+		_, err = io.ReadFull(conn, preface)
+		if err != nil {
+			return
+		}
+		serve(http2.NewFramer(conn, conn))
+	}()
+
+	return &Context{
+		Host:    "127.0.0.1",
+		Port:    ln.Addr().(*net.TCPAddr).Port,
+		Timeout: 300 * time.Millisecond,
+	}
+}
+
+// findTestCase returns the test case of tg or its children described
+// by desc.
+func findTestCase(t *testing.T, tg *TestGroup, desc string) *TestCase {
+	t.Helper()
+
+	for _, tc := range tg.testCases {
+		if tc.Desc == desc {
+			return tc
+		}
+	}
+	for _, child := range tg.testGroups {
+		for _, tc := range child.testCases {
+			if tc.Desc == desc {
+				return tc
+			}
+		}
+	}
+
+	t.Fatalf("test case %q not found", desc)
+	return nil
+}
+
+// serveSmallWindow answers the settings of the client with an initial
+// window size of window, and calls onData with every DATA frame and the
+// window of its stream left before it.
+func serveSmallWindow(window uint32, onData func(fr *http2.Framer, f *http2.DataFrame, left int64)) func(fr *http2.Framer) {
+	return func(fr *http2.Framer) {
+		fr.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: window})
+
+		left := map[uint32]int64{}
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			switch f := f.(type) {
+			case *http2.SettingsFrame:
+				if !f.IsAck() {
+					fr.WriteSettingsAck()
+				}
+			case *http2.PingFrame:
+				if !f.IsAck() {
+					fr.WritePing(true, f.Data)
+				}
+			case *http2.HeadersFrame:
+				left[f.StreamID] = int64(window)
+			case *http2.DataFrame:
+				onData(fr, f, left[f.StreamID])
+				left[f.StreamID] -= int64(f.Length)
+			}
+		}
+	}
+}
+
+func TestTrackSentOverrun(t *testing.T) {
+	write := func(fc *flowControl, pad int) {
+		var buf bytes.Buffer
+		fr := http2.NewFramer(&buf, nil)
+		fr.WriteDataPadded(1, false, make([]byte, 100), make([]byte, pad))
+		fc.Write(buf.Bytes())
+	}
+
+	fc := newFlowControl()
+	fc.streams[1] = 101
+	write(fc, 0)
+	if fc.exceeded() {
+		t.Errorf("DATA frame within the window reported as exceeding it")
+	}
+
+	fc = newFlowControl()
+	fc.streams[1] = 101
+	write(fc, 8)
+	if !fc.exceeded() {
+		t.Errorf("DATA frame exceeding the window by its padding not reported")
+	}
+
+	fc = newFlowControl()
+	fc.conn = 50
+	write(fc, 0)
+	if !fc.exceeded() {
+		t.Errorf("DATA frame exceeding the window of the connection not reported")
+	}
+}
+
+func TestFlowControlErrorByPadding(t *testing.T) {
+	const desc = "Sends a DATA frame exceeding the flow control window of a stream only by its padding"
+
+	// an endpoint ignoring the padding in the window fails the test
+	// case.
+	ctx := serveTestConn(t, serveSmallWindow(100, func(fr *http2.Framer, f *http2.DataFrame, left int64) {}))
+	tc := findTestCase(t, TheFlowControlWindowTestGroup(ctx), desc)
+	pass, _, actual := tc.handler(ctx)
+	if pass {
+		t.Errorf("lax endpoint: test case passed")
+	}
+	if _, ok := actual.(*ResultTestTimeout); !ok {
+		t.Errorf("lax endpoint: got %v, want a timeout", actual)
+	}
+
+	// an endpoint counting the padding passes it.
+	ctx = serveTestConn(t, serveSmallWindow(100, func(fr *http2.Framer, f *http2.DataFrame, left int64) {
+		if int64(f.Length) > left {
+			fr.WriteRSTStream(f.StreamID, http2.ErrCodeFlowControl)
+		}
+	}))
+	tc = findTestCase(t, TheFlowControlWindowTestGroup(ctx), desc)
+	pass, _, actual = tc.handler(ctx)
+	if !pass {
+		t.Errorf("strict endpoint: test case did not pass (%v)", actual)
+	}
+}
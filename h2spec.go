@@ -3,6 +3,7 @@ package h2spec
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -10,9 +11,9 @@ import (
 	"math"
 	"net"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -34,11 +35,33 @@ type Context struct {
 	Host      string
 	Strict    bool
 	Junit     string
+	Json      string
 	Tls       bool
 	TlsConfig *tls.Config
+	Alpn      []string // the protocols offered with ALPN, "h2" if empty
+	CACert    string   // PEM file of the CAs which verify the certificate of the server
+	Cert      string   // PEM file of the certificate of h2spec, for mutual TLS
+	Key       string   // PEM file of the private key of Cert
+	SNI       string   // the server name sent with SNI instead of Host
+	MinTls    uint16   // the minimum TLS version, the default of crypto/tls if 0
+	MaxTls    uint16   // the maximum TLS version, the default of crypto/tls if 0
+	KeyLog    string   // file to append the TLS secrets to, SSLKEYLOGFILE if empty
+	Pcap      string   // file to write a pcapng capture of the plaintext traffic to
 	Sections  map[string]bool
 	Timeout   time.Duration
-	Verbose   bool
+	Upgrade   bool   // connect with HTTP/1.1 Upgrade instead of prior knowledge
+	Verbose   bool   // print frame traces of failed test cases
+	TraceDir  string // directory to write the frame trace of each test case
+	Parallel  int    // the maximum number of test cases run concurrently
+	Dos       DosThresholds
+	Flood     bool   // run the control frame flood test cases
+	Scenarios string // directory of scenario files to run
+	Path      string // the path of a large resource for the flow control tests
+	report    *Report
+	testCase  *TestCase       // the test case being run with this context
+	listener  *clientListener // accepts clients under test in client mode
+	keyLog    *os.File        // the opened KeyLog file
+	pcap      *pcapWriter     // writes the capture to Pcap
 }
 
 func (ctx *Context) Authority() string {
@@ -52,6 +75,11 @@ func (ctx *Context) GetRunMode(section string) RunMode {
 
 	val, ok := ctx.Sections[section]
 	if !ok {
+		// the groups under a named group, like dos/rapid-reset, run
+		// with it.
+		if i := strings.Index(section, "/"); i > 0 && ctx.Sections[section[:i]] {
+			return ModeAll
+		}
 		return ModeSkip
 	}
 	if !val {
@@ -73,6 +101,9 @@ type TestGroup struct {
 	numTestCases int // the number of test cases under this group
 	numSkipped   int // the number of skipped test cases under this group
 	numFailed    int // the number of failed test cases under this group
+	numErrored   int // the number of test cases which ended with an error
+	numAdvisory  int // the number of test cases which raised an advisory
+	mu           sync.Mutex
 }
 
 func (tg *TestGroup) Run(ctx *Context) bool {
@@ -90,9 +121,14 @@ func (tg *TestGroup) Run(ctx *Context) bool {
 			switch testCase.Run(ctx) {
 			case Failed:
 				pass = false
-				tg.numFailed += 1
+				tg.addFailed(1)
+			case Errored:
+				pass = false
+				tg.addErrored(1)
+			case Advisory:
+				tg.addAdvisory(1)
 			case Skipped:
-				tg.numSkipped += 1
+				tg.addSkipped(1)
 			}
 		}
 		tg.PrintFooter()
@@ -100,7 +136,7 @@ func (tg *TestGroup) Run(ctx *Context) bool {
 		for _, testCase := range tg.testCases {
 			testCase.skipped = true
 		}
-		tg.numSkipped += tg.numTestCases
+		tg.addSkipped(tg.numTestCases)
 	}
 
 	for _, testGroup := range tg.testGroups {
@@ -114,10 +150,54 @@ func (tg *TestGroup) Run(ctx *Context) bool {
 	return pass
 }
 
-// PrintFailedTestCase prints failed TestCase results under this
-// TestGroup.
+// schedule marks the test cases under this TestGroup which are going
+// to be run as scheduled and returns them in the same order as Run
+// visits them.
+func (tg *TestGroup) schedule(ctx *Context) []*TestCase {
+	var testCases []*TestCase
+
+	if ctx.GetRunMode(tg.Section) == ModeAll {
+		for _, testCase := range tg.testCases {
+			testCase.done = make(chan struct{})
+			testCases = append(testCases, testCase)
+		}
+	}
+
+	for _, testGroup := range tg.testGroups {
+		testCases = append(testCases, testGroup.schedule(ctx)...)
+	}
+
+	return testCases
+}
+
+func (tg *TestGroup) addFailed(n int) {
+	tg.mu.Lock()
+	tg.numFailed += n
+	tg.mu.Unlock()
+}
+
+func (tg *TestGroup) addErrored(n int) {
+	tg.mu.Lock()
+	tg.numErrored += n
+	tg.mu.Unlock()
+}
+
+func (tg *TestGroup) addSkipped(n int) {
+	tg.mu.Lock()
+	tg.numSkipped += n
+	tg.mu.Unlock()
+}
+
+func (tg *TestGroup) addAdvisory(n int) {
+	tg.mu.Lock()
+	tg.numAdvisory += n
+	tg.mu.Unlock()
+}
+
+// PrintFailedTestCase prints failed and errored TestCase results
+// under this TestGroup.
 func (tg *TestGroup) PrintFailedTestCase(ctx *Context) {
-	if tg.CountFailed() == 0 {
+	if tg.CountFailed()+tg.CountErrored() == 0 {
 		return
 	}
 
@@ -126,10 +206,13 @@ func (tg *TestGroup) PrintFailedTestCase(ctx *Context) {
 
 	numTestCaseFailed := 0
 	for _, tc := range tg.testCases {
-		if tc.failed {
+		if tc.failed || tc.errored {
 			logger.LevelUp()
 
 			tc.PrintFail(tc.expected, tc.actual)
+			if ctx.Verbose {
+				tc.PrintTrace()
+			}
 			numTestCaseFailed += 1
 
 			logger.LevelDown()
@@ -147,15 +230,63 @@ func (tg *TestGroup) PrintFailedTestCase(ctx *Context) {
 	logger.LevelDown()
 }
 
+// PrintAdvisoryTestCase prints TestCase results which raised an
+// advisory under this TestGroup.
+func (tg *TestGroup) PrintAdvisoryTestCase(ctx *Context) {
+	if tg.CountAdvisory() == 0 {
+		return
+	}
+
+	logger.LevelUp()
+	tg.PrintHeader()
+
+	numTestCaseAdvisory := 0
+	for _, tc := range tg.testCases {
+		if tc.result == Advisory {
+			logger.LevelUp()
+
+			tc.PrintAdvisory(tc.expected, tc.actual)
+			if ctx.Verbose {
+				tc.PrintTrace()
+			}
+			numTestCaseAdvisory += 1
+
+			logger.LevelDown()
+		}
+	}
+
+	if numTestCaseAdvisory > 0 {
+		logger.WriteBlank()
+	}
+
+	for _, testGroup := range tg.testGroups {
+		testGroup.PrintAdvisoryTestCase(ctx)
+	}
+
+	logger.LevelDown()
+}
+
 func (tg *TestGroup) AddTestCase(testCase *TestCase) {
 	tg.testCases = append(tg.testCases, testCase)
 	tg.numTestCases += 1
+	testCase.section = tg.Section
+	testCase.seq = tg.numTestCases
 }
 
 func (tg *TestGroup) AddTestGroup(testGroup *TestGroup) {
 	tg.testGroups = append(tg.testGroups, testGroup)
 }
 
+// TestCases returns the test cases directly under this TestGroup.
+func (tg *TestGroup) TestCases() []*TestCase {
+	return tg.testCases
+}
+
+// TestGroups returns the child groups of this TestGroup.
+func (tg *TestGroup) TestGroups() []*TestGroup {
+	return tg.testGroups
+}
+
 func (tg *TestGroup) CountTestCases() int {
 	num := tg.numTestCases
 	for _, testGroup := range tg.testGroups {
@@ -166,7 +297,9 @@ func (tg *TestGroup) CountTestCases() int {
 }
 
 func (tg *TestGroup) CountSkipped() int {
+	tg.mu.Lock()
 	num := tg.numSkipped
+	tg.mu.Unlock()
 	for _, testGroup := range tg.testGroups {
 		num += testGroup.CountSkipped()
 	}
@@ -175,7 +308,9 @@ func (tg *TestGroup) CountSkipped() int {
 }
 
 func (tg *TestGroup) CountFailed() int {
+	tg.mu.Lock()
 	num := tg.numFailed
+	tg.mu.Unlock()
 	for _, testGroup := range tg.testGroups {
 		num += testGroup.CountFailed()
 	}
@@ -183,6 +318,28 @@ func (tg *TestGroup) CountFailed() int {
 	return num
 }
 
+func (tg *TestGroup) CountErrored() int {
+	tg.mu.Lock()
+	num := tg.numErrored
+	tg.mu.Unlock()
+	for _, testGroup := range tg.testGroups {
+		num += testGroup.CountErrored()
+	}
+
+	return num
+}
+
+func (tg *TestGroup) CountAdvisory() int {
+	tg.mu.Lock()
+	num := tg.numAdvisory
+	tg.mu.Unlock()
+	for _, testGroup := range tg.testGroups {
+		num += testGroup.CountAdvisory()
+	}
+
+	return num
+}
+
 func (tg *TestGroup) PrintHeader() {
 	logger.Write("%s. %s\n", tg.Section, tg.Name)
 }
@@ -201,53 +358,233 @@ const (
 	Failed TestResult = iota
 	Skipped
 	Passed
+	Errored  // the test could not be carried out, see ResultError
+	Advisory // an advisory test case found a weakness of the target
 )
 
+func (tr TestResult) String() string {
+	switch tr {
+	case Failed:
+		return "failed"
+	case Skipped:
+		return "skipped"
+	case Passed:
+		return "passed"
+	case Errored:
+		return "error"
+	case Advisory:
+		return "advisory"
+	}
+	return "unknown"
+}
+
 type TestCase struct {
 	Desc     string
 	Spec     string
 	handler  func(*Context) (bool, []Result, Result)
 	failed   bool          // true if test failed
+	advisory bool          // true if failures are reported as advisories
+	errored  bool          // true if test ended with an error
 	skipped  bool          // true if test has been skipped
 	expected []Result      // expected result
 	actual   Result        // actual result
 	testTime time.Duration // length of test execution
+	result   TestResult    // result of the last execution
+	done     chan struct{} // closed when a scheduled execution finished
+	trace    *Trace        // frames exchanged during the last execution
+	conns    []*Http2Conn  // connections opened during the last execution
+	ports    []int         // source ports of the connections of the last execution
+	section  string        // section of the group this test case belongs to
+	seq      int           // position of this test case in its group
+}
+
+// ID returns the identifier of the test case, which consists of the
+// section and the position in the group, like "6.5/2".
+func (tc *TestCase) ID() string {
+	return fmt.Sprintf("%s/%d", tc.section, tc.seq)
 }
 
+// Run runs the test case and prints its result.  If the test case has
+// been scheduled on a worker pool, Run waits for that execution to
+// finish instead of running the handler again.
 func (tc *TestCase) Run(ctx *Context) TestResult {
 	logger.LevelUp()
 
-	tc.PrintEphemeralDesc()
+	if tc.done != nil {
+		<-tc.done
+		// the scheduled execution is consumed, a later run executes
+		// the handler again unless it is scheduled again.
+		tc.done = nil
+	} else {
+		tc.PrintEphemeralDesc()
+		tc.execute(ctx)
+	}
+
+	switch tc.result {
+	case Skipped:
+		tc.PrintSkipped(tc.actual)
+	case Passed:
+		tc.PrintPass()
+	case Advisory:
+		tc.PrintAdvisory(tc.expected, tc.actual)
+	default:
+		tc.PrintFail(tc.expected, tc.actual)
+	}
+
+	logger.LevelDown()
+	return tc.result
+}
+
+// execute runs the handler of the test case and keeps its result.  It
+// does not print anything so that it can be called from any goroutine.
+func (tc *TestCase) execute(ctx *Context) {
+	// the handler gets its own copy of the context so that the
+	// connections it opens can be associated with this test case.
+	tcCtx := *ctx
+	tcCtx.testCase = tc
+
+	if ctx.Verbose || ctx.TraceDir != "" {
+		tc.trace = &Trace{}
+	}
+
+	tc.conns = nil
+	tc.ports = nil
 
 	startingTime := time.Now().UTC()
-	pass, expected, actual := tc.handler(ctx)
+	pass, expected, actual := tc.handler(&tcCtx)
 	endingTime := time.Now().UTC()
 	tc.testTime = endingTime.Sub(startingTime)
 
-	_, ok := actual.(*ResultSkipped)
-	if ok {
-		tc.skipped = true
-		tc.testTime = time.Duration(0)
-		tc.PrintSkipped(actual)
-		logger.LevelDown()
-		return Skipped
+	// a frame which breaks the protocol fails the test case even if
+	// the expected reaction was observed, and explains a frame which
+	// could not be read.  A suspicious frame raises an advisory.
+	warned := false
+	if _, errored := actual.(*ResultError); pass || errored {
+		if v, warning := tc.violation(); v != nil && (pass || !warning) {
+			pass = false
+			actual = v
+			warned = warning
+		}
 	}
+	tc.conns = nil
 
 	// keep expected and actual so that we can report the failed
 	// test cases in summary.
 	tc.expected = expected
 	tc.actual = actual
 
-	if pass {
-		tc.PrintPass()
-		logger.LevelDown()
-		return Passed
-	} else {
-		tc.failed = true
-		tc.PrintFail(expected, actual)
-		logger.LevelDown()
-		return Failed
+	switch actual.(type) {
+	case *ResultSkipped:
+		tc.skipped = true
+		tc.testTime = time.Duration(0)
+		tc.result = Skipped
+	case *ResultError:
+		if pass {
+			tc.result = Passed
+		} else {
+			tc.errored = true
+			tc.result = Errored
+		}
+	case *ResultInconclusive:
+		if pass {
+			tc.result = Passed
+		} else {
+			tc.result = Advisory
+		}
+	default:
+		if pass {
+			tc.result = Passed
+		} else if tc.advisory || warned {
+			tc.result = Advisory
+		} else {
+			tc.failed = true
+			tc.result = Failed
+		}
+	}
+
+	if ctx.TraceDir != "" {
+		tc.writeTrace(ctx.TraceDir)
+	}
+
+	if tc.done != nil {
+		close(tc.done)
+	}
+}
+
+// addConn associates h2Conn with the running execution of the test
+// case.
+func (tc *TestCase) addConn(h2Conn *Http2Conn) {
+	tc.conns = append(tc.conns, h2Conn)
+}
+
+// addPort records the source port of a connection opened by the running
+// execution of the test case, which identifies it in a capture.
+func (tc *TestCase) addPort(addr net.Addr) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		tc.ports = append(tc.ports, tcpAddr.Port)
+	}
+}
+
+// violation returns the first frame breaking the protocol which was
+// received on the connections of the last execution, or the first
+// suspicious frame if there is none.  warning is true in the latter
+// case.
+func (tc *TestCase) violation() (v Result, warning bool) {
+	var warn Result
+	for _, h2Conn := range tc.conns {
+		if illegal := h2Conn.IllegalFrames(); len(illegal) > 0 {
+			return illegal[0], false
+		}
+		for _, rv := range h2Conn.Violations() {
+			if !rv.Warning {
+				return rv, false
+			}
+			if warn == nil {
+				warn = rv
+			}
+		}
+	}
+	return warn, warn != nil
+}
+
+// writeTrace writes the frame trace of the test case into a file
+// under dir named after the test case ID.
+func (tc *TestCase) writeTrace(dir string) {
+	name := strings.Replace(tc.ID(), "/", "-", -1) + ".log"
+
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		tc.trace.Logf("unable to write trace (%v)", err)
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "%s %s\n", tc.ID(), tc.Desc)
+	fmt.Fprintf(f, "  - %s\n\n", tc.Spec)
+	tc.trace.WriteTo(f)
+}
+
+// Result returns the result of the last execution of the test case.
+func (tc *TestCase) Result() TestResult {
+	if tc.skipped {
+		return Skipped
 	}
+	return tc.result
+}
+
+// Expected returns the results which would have passed the test case.
+func (tc *TestCase) Expected() []Result {
+	return tc.expected
+}
+
+// Actual returns the result observed during the test case.
+func (tc *TestCase) Actual() Result {
+	return tc.actual
+}
+
+// Duration returns the time taken by the test case.
+func (tc *TestCase) Duration() time.Duration {
+	return tc.testTime
 }
 
 func (tc *TestCase) HandleFunc(handler func(*Context) (bool, []Result, Result)) {
@@ -291,6 +628,44 @@ func (tc *TestCase) PrintFail(expected []Result, actual Result) {
 	logger.ResetColor()
 }
 
+func (tc *TestCase) PrintAdvisory(expected []Result, actual Result) {
+	mark := "!"
+
+	logger.Clear()
+
+	logger.SetColor("yellow")
+	logger.Write("%s %s\n", mark, tc.Desc)
+	logger.Write("  - %s\n", tc.Spec)
+
+	logger.SetColor("green")
+	for i, exp := range expected {
+		var lavel string
+		if i == 0 {
+			lavel = "Expected:"
+		} else {
+			lavel = strings.Repeat(" ", 9)
+		}
+		logger.Write("    %s %s\n", lavel, exp)
+	}
+
+	logger.SetColor("yellow")
+	logger.Write("      Actual: %s\n", actual)
+	logger.ResetColor()
+}
+
+// PrintTrace prints the frames exchanged during the test case.
+func (tc *TestCase) PrintTrace() {
+	if tc.trace == nil {
+		return
+	}
+
+	logger.SetColor("gray")
+	for _, line := range tc.trace.Lines() {
+		logger.Write("      %s\n", line)
+	}
+	logger.ResetColor()
+}
+
 func (tc *TestCase) PrintSkipped(actual Result) {
 	mark := " "
 
@@ -317,24 +692,46 @@ func NewTestCase(desc, spec string, handler func(*Context) (bool, []Result, Resu
 	}
 }
 
+// NewAdvisoryTestCase returns a test case which checks a recommended
+// behavior rather than a requirement of the RFC.  If it does not pass,
+// it raises an advisory instead of failing.
+func NewAdvisoryTestCase(desc, spec string, handler func(*Context) (bool, []Result, Result)) *TestCase {
+	tc := NewTestCase(desc, spec, handler)
+	tc.advisory = true
+	return tc
+}
+
 type Logger struct {
 	IndentLevel int
+	mu          sync.Mutex
 }
 
 func (log *Logger) Write(format string, a ...interface{}) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	indent := strings.Repeat("  ", log.IndentLevel)
 	fmt.Printf("%s%s", indent, fmt.Sprintf(format, a...))
 }
 
 func (log *Logger) WriteBlank() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	fmt.Println("")
 }
 
 func (log *Logger) Clear() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	fmt.Printf("\r")
 }
 
-func (Log *Logger) SetColor(color string) {
+func (log *Logger) SetColor(color string) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	switch color {
 	case "green":
 		fmt.Printf("\x1b[32m")
@@ -349,21 +746,30 @@ func (Log *Logger) SetColor(color string) {
 	}
 }
 
-func (Log *Logger) ResetColor() {
+func (log *Logger) ResetColor() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	fmt.Printf("\x1b[0m")
 }
 
 func (log *Logger) LevelUp() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	log.IndentLevel++
 }
 
 func (log *Logger) LevelDown() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
 	if log.IndentLevel > 0 {
 		log.IndentLevel--
 	}
 }
 
-var logger *Logger = &Logger{0}
+var logger *Logger = &Logger{}
 
 var LengthDefault uint32 = math.MaxUint32
 var FlagDefault http2.Flags = math.MaxUint8
@@ -419,6 +825,153 @@ func (ttr *ResultTestTimeout) String() string {
 	return "Test timeout"
 }
 
+type ResultHttp1Response struct {
+	Status string
+}
+
+func (rhr *ResultHttp1Response) String() string {
+	return fmt.Sprintf("HTTP/1.1 response (Status: %s)", rhr.Status)
+}
+
+type ResultNoUpgrade struct{}
+
+func (rnu *ResultNoUpgrade) String() string {
+	return "HTTP/1.1 response without upgrade"
+}
+
+// ResultHandshakeFailure describes a TLS handshake refused by the
+// endpoint.
+type ResultHandshakeFailure struct {
+	Err error
+}
+
+func (rhf *ResultHandshakeFailure) String() string {
+	if rhf.Err == nil {
+		return "TLS handshake failure"
+	}
+	return fmt.Sprintf("TLS handshake failure (%s)", rhf.Err)
+}
+
+// ResultNegotiatedProtocol describes the protocol selected by the
+// endpoint with ALPN, which is not HTTP/2 if expected.
+type ResultNegotiatedProtocol struct {
+	Protocol string
+}
+
+func (rnp *ResultNegotiatedProtocol) String() string {
+	if rnp.Protocol == "" {
+		return "HTTP/2 not negotiated"
+	}
+	if strings.HasPrefix(rnp.Protocol, "h2-") {
+		return fmt.Sprintf("Draft of HTTP/2 negotiated (Protocol: %s)", rnp.Protocol)
+	}
+	if isHttp2Protocol(rnp.Protocol) {
+		return fmt.Sprintf("HTTP/2 negotiated (Protocol: %s)", rnp.Protocol)
+	}
+	return fmt.Sprintf("HTTP/2 not negotiated (Protocol: %s)", rnp.Protocol)
+}
+
+// ResultTlsCompression describes the compression method selected by the
+// endpoint in the ServerHello.
+type ResultTlsCompression struct {
+	Method uint8
+}
+
+func (rtc *ResultTlsCompression) String() string {
+	if rtc.Method == 0 {
+		return "TLS compression disabled"
+	}
+	return fmt.Sprintf("TLS compression method %d selected", rtc.Method)
+}
+
+// ResultTlsRenegotiation describes the reaction of the endpoint to a
+// TLS renegotiation, which is refused with Alert unless Accepted.
+type ResultTlsRenegotiation struct {
+	Accepted bool
+	Alert    error
+}
+
+func (rtr *ResultTlsRenegotiation) String() string {
+	if rtr.Accepted {
+		return "TLS renegotiation accepted"
+	}
+	if rtr.Alert == nil {
+		return "TLS renegotiation refused"
+	}
+	return fmt.Sprintf("TLS renegotiation refused (%s)", rtr.Alert)
+}
+
+type ResultMalformedHeader struct {
+	Reason string
+}
+
+func (rmh *ResultMalformedHeader) String() string {
+	return fmt.Sprintf("Malformed header list (%s)", rmh.Reason)
+}
+
+// ResultIllegalFrame describes a frame which the endpoint sent on a
+// stream in a state that does not permit it.
+type ResultIllegalFrame struct {
+	Type     http2.FrameType
+	StreamID uint32
+	State    StreamState
+}
+
+func (rif *ResultIllegalFrame) String() string {
+	return fmt.Sprintf("Illegal %s frame on stream %d in %s state", rif.Type, rif.StreamID, rif.State)
+}
+
+// ResultFlowControl describes the DATA octets which the endpoint sent
+// on a stream against the flow control window advertised by h2spec.
+// Octets of -1 stands for any amount within the window.
+type ResultFlowControl struct {
+	Octets int64
+	Window int64
+}
+
+func (rfc *ResultFlowControl) String() string {
+	if rfc.Octets < 0 {
+		return fmt.Sprintf("DATA frames within the flow control window of %d octets", rfc.Window)
+	}
+	return fmt.Sprintf("DATA frames of %d octets on the flow control window of %d octets", rfc.Octets, rfc.Window)
+}
+
+// ResultInconclusive describes an unexpected reaction of the endpoint
+// which may have been caused by frames in flight rather than by the
+// endpoint.  It raises an advisory instead of failing the test case.
+type ResultInconclusive struct {
+	Actual Result
+	Reason string
+}
+
+func (ri *ResultInconclusive) String() string {
+	return fmt.Sprintf("%s (%s)", ri.Actual, ri.Reason)
+}
+
+type ResultBackPressure struct{}
+
+func (rbp *ResultBackPressure) String() string {
+	return "Back-pressure (the endpoint stopped reading)"
+}
+
+// ResultFlood describes how the endpoint reacted to a flood of frames.
+type ResultFlood struct {
+	Reaction Result // nil if the endpoint did not react
+	Frames   int    // the number of frames sent before the reaction
+	Octets   int    // the number of octets sent before the reaction
+	Elapsed  time.Duration
+}
+
+func (rf *ResultFlood) String() string {
+	reaction := "No reaction"
+	if rf.Reaction != nil {
+		reaction = rf.Reaction.String()
+	}
+
+	elapsed := rf.Elapsed - rf.Elapsed%time.Millisecond
+	return fmt.Sprintf("%s after %d frames (%d octets, %s)", reaction, rf.Frames, rf.Octets, elapsed)
+}
+
 type ResultSkipped struct {
 	Reason string
 }
@@ -442,13 +995,26 @@ type TcpConn struct {
 }
 
 type Http2Conn struct {
-	conn           net.Conn
-	dataCh         chan http2.Frame
-	errCh          chan error
-	fr             *http2.Framer
-	HpackEncoder   *hpack.Encoder
-	HeaderWriteBuf bytes.Buffer
-	Settings       map[http2.SettingID]uint32
+	conn            net.Conn
+	dataCh          chan http2.Frame
+	errCh           chan error
+	fr              *http2.Framer
+	HpackEncoder    *hpack.Encoder
+	HpackDecoder    *hpack.Decoder
+	HeaderWriteBuf  bytes.Buffer
+	Settings        map[http2.SettingID]uint32
+	RequestStreamID uint32 // the stream of the client request in client mode
+
+	reading        bool   // a frame is being read by ReadFrame
+	headerStreamID uint32 // the stream of the header block in progress
+	headerBlock    []byte // the header block in progress
+	headerLists    map[uint32][][]hpack.HeaderField
+	headerErr      error
+	streams        *streamTracker
+	monitor        *frameMonitor
+	flow           *flowControl
+	lastFrame      []byte        // the raw frame read last
+	backlog        []http2.Frame // frames to return before reading more
 }
 
 // ReadFrame reads a complete HTTP/2 frame from underlying connection.
@@ -456,19 +1022,40 @@ type Http2Conn struct {
 // t is expired.  The returned http2.Frame must not be used after next
 // ReadFrame call.
 func (h2Conn *Http2Conn) ReadFrame(t time.Duration) (http2.Frame, error) {
-	go func() {
-		f, err := h2Conn.fr.ReadFrame()
-		if err != nil {
-			h2Conn.errCh <- err
-			return
-		}
-		h2Conn.dataCh <- f
-	}()
+	// frames read while SendBody waited for a window update come
+	// first.
+	if len(h2Conn.backlog) > 0 {
+		f := h2Conn.backlog[0]
+		h2Conn.backlog = h2Conn.backlog[1:]
+		return f, nil
+	}
+
+	return h2Conn.readFrame(t)
+}
+
+// readFrame reads a frame from the connection, bypassing the backlog.
+func (h2Conn *Http2Conn) readFrame(t time.Duration) (http2.Frame, error) {
+	// a read left over by a timed out call is still in progress, so
+	// wait for its frame instead of reading concurrently.
+	if !h2Conn.reading {
+		h2Conn.reading = true
+		go func() {
+			f, err := h2Conn.fr.ReadFrame()
+			if err != nil {
+				h2Conn.errCh <- err
+				return
+			}
+			h2Conn.dataCh <- f
+		}()
+	}
 
 	select {
 	case f := <-h2Conn.dataCh:
+		h2Conn.reading = false
+		h2Conn.receive(f)
 		return f, nil
 	case err := <-h2Conn.errCh:
+		h2Conn.reading = false
 		return nil, err
 	case <-time.After(t):
 		return nil, TIMEOUT
@@ -491,31 +1078,222 @@ func (h2Conn *Http2Conn) EncodeHeader(header []hpack.HeaderField) []byte {
 	return dst
 }
 
-func connectTls(ctx *Context) (net.Conn, error) {
-	if ctx.TlsConfig == nil {
-		ctx.TlsConfig = new(tls.Config)
+// receive updates the state of the connection with f read from the
+// peer.
+func (h2Conn *Http2Conn) receive(f http2.Frame) {
+	h2Conn.decodeHeader(f)
+	h2Conn.streams.recv(f)
+	h2Conn.flow.recv(f)
+}
+
+// accepted records that the connection was accepted from the client
+// under test, so h2spec is the server.
+func (h2Conn *Http2Conn) accepted() {
+	h2Conn.streams.server = true
+	h2Conn.monitor.server = true
+}
+
+// upgraded records that stream 1 was used by the HTTP/1.1 request
+// which upgraded the connection, so it is half-closed (local).
+func (h2Conn *Http2Conn) upgraded() {
+	h2Conn.streams.mu.Lock()
+	defer h2Conn.streams.mu.Unlock()
+
+	h2Conn.streams.opened(1)
+	h2Conn.streams.states[1] = StateHalfClosedLocal
+}
+
+// finishUpgrade forgets the response to the upgrade request once it
+// has been read, so that stream 1 is closed and its header lists do not
+// mix with the responses the test cases expect.
+func (h2Conn *Http2Conn) finishUpgrade() {
+	delete(h2Conn.headerLists, 1)
+
+	h2Conn.streams.mu.Lock()
+	defer h2Conn.streams.mu.Unlock()
+
+	h2Conn.streams.states[1] = StateClosed
+}
+
+// decodeHeader passes the header block fragment carried by f to the
+// HPACK decoder.  Every header block received must be decoded to keep
+// the dynamic table in sync with the peer.  The decoded header lists
+// are kept per stream and can be retrieved with HeaderLists.
+func (h2Conn *Http2Conn) decodeHeader(f http2.Frame) {
+	var streamID uint32
+	var endHeaders bool
+
+	switch f := f.(type) {
+	case *http2.HeadersFrame:
+		streamID = f.StreamID
+		endHeaders = f.HeadersEnded()
+		h2Conn.headerBlock = append(h2Conn.headerBlock[:0], f.HeaderBlockFragment()...)
+	case *http2.PushPromiseFrame:
+		// the request of a pushed stream belongs to the promised
+		// stream.
+		streamID = f.PromiseID
+		endHeaders = f.HeadersEnded()
+		h2Conn.headerBlock = append(h2Conn.headerBlock[:0], f.HeaderBlockFragment()...)
+	case *http2.ContinuationFrame:
+		if h2Conn.headerStreamID == 0 {
+			return
+		}
+		streamID = h2Conn.headerStreamID
+		endHeaders = f.HeadersEnded()
+		h2Conn.headerBlock = append(h2Conn.headerBlock, f.HeaderBlockFragment()...)
+	default:
+		return
 	}
 
-	if ctx.TlsConfig.NextProtos == nil {
-		ctx.TlsConfig.NextProtos = append(ctx.TlsConfig.NextProtos, "h2-14", "h2-15", "h2-16", "h2")
+	if !endHeaders {
+		h2Conn.headerStreamID = streamID
+		return
 	}
+	h2Conn.headerStreamID = 0
+
+	hl, err := h2Conn.HpackDecoder.DecodeFull(h2Conn.headerBlock)
+	if err != nil {
+		if h2Conn.headerErr == nil {
+			h2Conn.headerErr = err
+		}
+		return
+	}
+
+	h2Conn.headerLists[streamID] = append(h2Conn.headerLists[streamID], hl)
+}
+
+// HeaderLists returns the header lists decoded from the header blocks
+// received on streamID so far, in the order they were received.
+func (h2Conn *Http2Conn) HeaderLists(streamID uint32) [][]hpack.HeaderField {
+	return h2Conn.headerLists[streamID]
+}
+
+// HeaderError returns the first error which occurred in decoding the
+// header blocks received from the peer, if any.
+func (h2Conn *Http2Conn) HeaderError() error {
+	return h2Conn.headerErr
+}
+
+func connectTls(ctx *Context) (net.Conn, error) {
+	config := tlsConfig(ctx)
 
 	dialer := new(net.Dialer)
 	dialer.Timeout = ctx.Timeout
-	conn, err := tls.DialWithDialer(dialer, "tcp", ctx.Authority(), ctx.TlsConfig)
+	conn, err := tls.DialWithDialer(dialer, "tcp", ctx.Authority(), config)
 	if err != nil {
 		return nil, err
 	}
 
 	cs := conn.ConnectionState()
-	if !cs.NegotiatedProtocolIsMutual {
-		return nil, fmt.Errorf("HTTP/2 protocol was not negotiated")
+	if ctx.report != nil {
+		ctx.report.Target.recordConnState(cs)
+	}
+
+	// drafts of HTTP/2 may be offered with --alpn, but the endpoint
+	// must select the final version.
+	if cs.NegotiatedProtocol != "h2" {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP/2 protocol was not negotiated (Protocol: %q)", cs.NegotiatedProtocol)
 	}
 
 	return conn, err
 }
 
-func CreateTcpConn(ctx *Context) *TcpConn {
+// tlsConfig returns the TLS configuration to connect to the target
+// server, which offers the protocols of ctx.Alpn with ALPN.
+func tlsConfig(ctx *Context) *tls.Config {
+	// test cases may run concurrently, so work on a copy of the
+	// shared configuration.
+	var config *tls.Config
+	if ctx.TlsConfig == nil {
+		config = new(tls.Config)
+	} else {
+		config = ctx.TlsConfig.Clone()
+	}
+
+	if config.NextProtos == nil {
+		if len(ctx.Alpn) > 0 {
+			config.NextProtos = append(config.NextProtos, ctx.Alpn...)
+		} else {
+			config.NextProtos = append(config.NextProtos, "h2")
+		}
+	}
+
+	return config
+}
+
+// loadTlsConfig applies the TLS options of ctx to ctx.TlsConfig, so
+// that the files are read only once for all the connections.  The key
+// log file is kept open until closeKeyLog is called.
+func (ctx *Context) loadTlsConfig() error {
+	var config *tls.Config
+	if ctx.TlsConfig == nil {
+		config = new(tls.Config)
+	} else {
+		config = ctx.TlsConfig.Clone()
+	}
+
+	if ctx.CACert != "" {
+		pem, err := ioutil.ReadFile(ctx.CACert)
+		if err != nil {
+			return fmt.Errorf("Unable to read the CA certificates (%v)", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No CA certificate found in %s", ctx.CACert)
+		}
+	}
+
+	if ctx.Cert != "" || ctx.Key != "" {
+		if ctx.Cert == "" || ctx.Key == "" {
+			return fmt.Errorf("Both a certificate and its private key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(ctx.Cert, ctx.Key)
+		if err != nil {
+			return fmt.Errorf("Unable to load the certificate (%v)", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if ctx.SNI != "" {
+		config.ServerName = ctx.SNI
+	}
+	if ctx.MinTls != 0 {
+		config.MinVersion = ctx.MinTls
+	}
+	if ctx.MaxTls != 0 {
+		config.MaxVersion = ctx.MaxTls
+	}
+
+	// the secrets let Wireshark decrypt a capture of the run.
+	keyLog := ctx.KeyLog
+	if keyLog == "" {
+		keyLog = os.Getenv("SSLKEYLOGFILE")
+	}
+	if keyLog != "" {
+		f, err := os.OpenFile(keyLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("Unable to open the key log file (%v)", err)
+		}
+		ctx.keyLog = f
+		config.KeyLogWriter = f
+	}
+
+	ctx.TlsConfig = config
+	return nil
+}
+
+// closeKeyLog closes the key log file opened by loadTlsConfig.
+func (ctx *Context) closeKeyLog() {
+	if ctx.keyLog != nil {
+		ctx.keyLog.Close()
+		ctx.keyLog = nil
+	}
+}
+
+// connect opens a connection to the target server, over TLS if
+// ctx.Tls is set.
+func connect(ctx *Context) (net.Conn, error) {
 	var conn net.Conn
 	var err error
 
@@ -526,8 +1304,26 @@ func CreateTcpConn(ctx *Context) *TcpConn {
 	}
 
 	if err != nil {
-		printError(fmt.Sprintf("Unable to connect to the target server (%v)", err))
-		os.Exit(1)
+		return nil, fmt.Errorf("Unable to connect to the target server (%v)", err)
+	}
+
+	if ctx.testCase != nil {
+		ctx.testCase.addPort(conn.LocalAddr())
+	}
+	conn = ctx.capture(conn, false)
+	if ctx.testCase != nil && ctx.testCase.trace != nil {
+		conn = ctx.testCase.trace.traceConn(conn, ctx.Upgrade && !ctx.Tls)
+	}
+
+	return conn, nil
+}
+
+// CreateTcpConn opens a raw connection to the target server.  An
+// error is returned if the server cannot be reached.
+func CreateTcpConn(ctx *Context) (*TcpConn, error) {
+	conn, err := connect(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	dataCh := make(chan []byte)
@@ -551,28 +1347,43 @@ func CreateTcpConn(ctx *Context) *TcpConn {
 		}
 	}()
 
-	return tcpConn
+	return tcpConn, nil
 }
 
-func CreateHttp2Conn(ctx *Context, sn bool) *Http2Conn {
-	var conn net.Conn
-	var err error
-
-	if ctx.Tls {
-		conn, err = connectTls(ctx)
-	} else {
-		conn, err = net.DialTimeout("tcp", ctx.Authority(), ctx.Timeout)
-	}
-
+// CreateHttp2Conn opens a connection to the target server and sends
+// the connection preface.  If sn is true, it also waits until SETTINGS
+// frames have been exchanged in both directions.  An error is returned
+// if the server cannot be reached or the negotiation fails.
+func CreateHttp2Conn(ctx *Context, sn bool) (*Http2Conn, error) {
+	conn, err := connect(ctx)
 	if err != nil {
-		printError(fmt.Sprintf("Unable to connect to the target server (%v)", err))
-		os.Exit(1)
+		return nil, err
 	}
 
-	fmt.Fprintf(conn, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	// with h2c upgrade, the response to the upgrade request comes on
+	// stream 1 and is consumed during the settings negotiation.
+	upgraded := ctx.Upgrade && !ctx.Tls
+	if upgraded {
+		br, err := upgrade(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		fmt.Fprintf(conn, clientPreface)
+		conn = &bufferedConn{conn, br}
+	} else {
+		fmt.Fprintf(conn, clientPreface)
+	}
 
-	fr := http2.NewFramer(conn, conn)
 	settings := map[http2.SettingID]uint32{}
+	http2Conn := newHttp2Conn(conn, settings)
+	fr := http2Conn.fr
+	if upgraded {
+		http2Conn.upgraded()
+	}
+	if ctx.testCase != nil {
+		ctx.testCase.addConn(http2Conn)
+	}
 
 	if sn {
 		doneCh := make(chan bool, 1)
@@ -582,6 +1393,7 @@ func CreateHttp2Conn(ctx *Context, sn bool) *Http2Conn {
 		go func() {
 			local := false
 			remote := false
+			upgrading := upgraded
 
 			for {
 				f, err := fr.ReadFrame()
@@ -589,6 +1401,7 @@ func CreateHttp2Conn(ctx *Context, sn bool) *Http2Conn {
 					errCh <- err
 					return
 				}
+				http2Conn.receive(f)
 
 				switch f := f.(type) {
 				case *http2.SettingsFrame:
@@ -602,9 +1415,24 @@ func CreateHttp2Conn(ctx *Context, sn bool) *Http2Conn {
 						fr.WriteSettingsAck()
 						remote = true
 					}
+				case *http2.HeadersFrame:
+					if f.StreamID == 1 && f.StreamEnded() {
+						upgrading = false
+						http2Conn.finishUpgrade()
+					}
+				case *http2.DataFrame:
+					if f.StreamID == 1 && f.StreamEnded() {
+						upgrading = false
+						http2Conn.finishUpgrade()
+					}
+				case *http2.RSTStreamFrame:
+					if f.StreamID == 1 {
+						upgrading = false
+						http2Conn.finishUpgrade()
+					}
 				}
 
-				if local && remote {
+				if local && remote && !upgrading {
 					doneCh <- true
 					return
 				}
@@ -614,28 +1442,49 @@ func CreateHttp2Conn(ctx *Context, sn bool) *Http2Conn {
 		select {
 		case <-doneCh:
 			// Nothing to do.
-		case <-errCh:
-			printError("HTTP/2 settings negotiation failed")
-			os.Exit(1)
+		case err := <-errCh:
+			conn.Close()
+			return nil, fmt.Errorf("HTTP/2 settings negotiation failed (%v)", err)
 		case <-time.After(ctx.Timeout):
-			printError("HTTP/2 settings negotiation timeout")
-			os.Exit(1)
+			conn.Close()
+			return nil, fmt.Errorf("HTTP/2 settings negotiation timeout")
 		}
 	}
 
-	fr.AllowIllegalWrites = true
-	dataCh := make(chan http2.Frame)
-	errCh := make(chan error, 1)
+	if ctx.report != nil && sn {
+		ctx.report.Target.recordSettings(settings)
+	}
+
+	return http2Conn, nil
+}
 
+// newHttp2Conn returns an Http2Conn which exchanges frames over conn.
+// The connection preface must have been sent already.
+func newHttp2Conn(conn net.Conn, settings map[http2.SettingID]uint32) *Http2Conn {
 	http2Conn := &Http2Conn{
-		conn:     conn,
-		fr:       fr,
-		dataCh:   dataCh,
-		errCh:    errCh,
+		dataCh:   make(chan http2.Frame),
+		errCh:    make(chan error, 1),
 		Settings: settings,
+
+		headerLists: map[uint32][][]hpack.HeaderField{},
+		streams:     newStreamTracker(false),
+		monitor:     newFrameMonitor(),
+		flow:        newFlowControl(),
 	}
 
+	// every frame written, including the raw bytes written by test
+	// cases, goes through the stream tracker and the flow control, and
+	// every frame read goes through the monitor before the framer
+	// parses it.
+	out := io.MultiWriter(http2Conn.streams, sentMonitor{http2Conn.monitor}, http2Conn.flow)
+	in := io.MultiWriter(http2Conn.monitor, &frameSplitter{fn: http2Conn.recordFrame})
+	http2Conn.conn = &tapConn{Conn: conn, in: in, out: out}
+
+	http2Conn.fr = http2.NewFramer(http2Conn.conn, http2Conn.conn)
+	http2Conn.fr.AllowIllegalWrites = true
+
 	http2Conn.HpackEncoder = hpack.NewEncoder(&http2Conn.HeaderWriteBuf)
+	http2Conn.HpackDecoder = hpack.NewDecoder(4096, nil)
 
 	return http2Conn
 }
@@ -694,146 +1543,27 @@ func CreateHttp2Conn(ctx *Context, sn bool) *Http2Conn {
 //}
 
 func TestConnectionError(ctx *Context, http2Conn *Http2Conn, codes []http2.ErrCode) (pass bool, expected []Result, actual Result) {
+	var exps []Expectation
 	for _, code := range codes {
-		expected = append(expected, &ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, code})
+		exps = append(exps, ExpectGoAway(code))
 	}
-	expected = append(expected, &ResultConnectionClose{})
+	exps = append(exps, ExpectConnectionClose())
 
-loop:
-	for {
-		f, err := http2Conn.ReadFrame(ctx.Timeout)
-		if err != nil {
-			opErr, ok := err.(*net.OpError)
-			if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-				rf, ok := actual.(*ResultFrame)
-				if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-					actual = &ResultConnectionClose{}
-					pass = true
-				}
-			} else if err == TIMEOUT {
-				if actual == nil {
-					actual = &ResultTestTimeout{}
-				}
-			} else {
-				actual = &ResultError{err}
-			}
-			break loop
-		}
-
-		switch f := f.(type) {
-		case *http2.GoAwayFrame:
-			actual = CreateResultFrame(f)
-			if TestErrorCode(f.ErrCode, codes) {
-				pass = true
-			}
-			break loop
-		default:
-			actual = CreateResultFrame(f)
-		}
-	}
-
-	return pass, expected, actual
+	return http2Conn.Expect(ctx, exps...)
 }
 
 func TestStreamError(ctx *Context, http2Conn *Http2Conn, codes []http2.ErrCode) (pass bool, expected []Result, actual Result) {
-	pass = false
-
+	var exps []Expectation
 	for _, code := range codes {
-		expected = append(expected, &ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, code})
-		expected = append(expected, &ResultFrame{LengthDefault, http2.FrameRSTStream, FlagDefault, code})
-	}
-	expected = append(expected, &ResultConnectionClose{})
-
-loop:
-	for {
-		f, err := http2Conn.ReadFrame(ctx.Timeout)
-		if err != nil {
-			opErr, ok := err.(*net.OpError)
-			if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-				rf, ok := actual.(*ResultFrame)
-				if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-					actual = &ResultConnectionClose{}
-					pass = true
-				}
-			} else if err == TIMEOUT {
-				if actual == nil {
-					actual = &ResultTestTimeout{}
-				}
-			} else {
-				actual = &ResultError{err}
-			}
-			break loop
-		}
-
-		switch f := f.(type) {
-		case *http2.GoAwayFrame:
-			actual = CreateResultFrame(f)
-			if TestErrorCode(f.ErrCode, codes) {
-				pass = true
-			}
-			break loop
-		case *http2.RSTStreamFrame:
-			actual = CreateResultFrame(f)
-			if TestErrorCode(f.ErrCode, codes) {
-				pass = true
-			}
-			break loop
-		default:
-			actual = CreateResultFrame(f)
-		}
+		exps = append(exps, ExpectGoAway(code), ExpectRstStream(code))
 	}
+	exps = append(exps, ExpectConnectionClose())
 
-	return pass, expected, actual
+	return http2Conn.Expect(ctx, exps...)
 }
 
 func TestStreamClose(ctx *Context, http2Conn *Http2Conn) (pass bool, expected []Result, actual Result) {
-	pass = false
-	expected = append(expected, &ResultStreamClose{})
-
-loop:
-	for {
-		f, err := http2Conn.ReadFrame(ctx.Timeout)
-		if err != nil {
-			opErr, ok := err.(*net.OpError)
-			if err == io.EOF || (ok && opErr.Err == syscall.ECONNRESET) {
-				rf, ok := actual.(*ResultFrame)
-				if actual == nil || (ok && rf.Type != http2.FrameGoAway) {
-					actual = &ResultConnectionClose{}
-					pass = true
-				}
-			} else if err == TIMEOUT {
-				if actual == nil {
-					actual = &ResultTestTimeout{}
-				}
-			} else {
-				actual = &ResultError{err}
-			}
-			break loop
-		}
-
-		switch f := f.(type) {
-		case *http2.DataFrame:
-			if f.StreamEnded() {
-				pass = true
-				actual = &ResultStreamClose{}
-				break loop
-			} else {
-				actual = CreateResultFrame(f)
-			}
-		case *http2.HeadersFrame:
-			if f.StreamEnded() {
-				pass = true
-				actual = &ResultStreamClose{}
-				break loop
-			} else {
-				actual = CreateResultFrame(f)
-			}
-		default:
-			actual = CreateResultFrame(f)
-		}
-	}
-
-	return pass, expected, actual
+	return http2Conn.Expect(ctx, ExpectStreamClose(0), ExpectConnectionClose())
 }
 
 func TestErrorCode(code http2.ErrCode, expected []http2.ErrCode) bool {
@@ -929,34 +1659,122 @@ func pair(name, value string) hpack.HeaderField {
 	return hpack.HeaderField{Name: name, Value: value}
 }
 
-func printError(err string) {
-	logger.SetColor("red")
-	logger.Write("\n\nERROR: %s", err)
-	logger.ResetColor()
+// connectionSpecificHeaders are the header fields which must not appear
+// in HTTP/2 messages (RFC 7540, 8.1.2.2).
+var connectionSpecificHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"transfer-encoding",
+	"upgrade",
 }
 
-// printSummary prints out the test summary of all tests performed.
-func printSummary(ctx *Context, groups []*TestGroup) {
-	numTestCases := 0
-	numSkipped := 0
-	numFailed := 0
+// CheckResponseHeader returns an error describing why hl is not a
+// well-formed response header list (RFC 7540, 8.1.2), or nil.
+func CheckResponseHeader(hl []hpack.HeaderField) error {
+	status := 0
+	regular := false
+
+	for _, hf := range hl {
+		if hf.Name != strings.ToLower(hf.Name) {
+			return fmt.Errorf("header field name %q is not lowercase", hf.Name)
+		}
+
+		if strings.HasPrefix(hf.Name, ":") {
+			if regular {
+				return fmt.Errorf("pseudo-header field %q after regular header fields", hf.Name)
+			}
+			if hf.Name != ":status" {
+				return fmt.Errorf("pseudo-header field %q is not defined for responses", hf.Name)
+			}
 
-	for _, tg := range groups {
-		if tg == nil {
+			status++
+			if len(hf.Value) != 3 || strings.Trim(hf.Value, "0123456789") != "" {
+				return fmt.Errorf(":status %q is not a 3-digit status code", hf.Value)
+			}
 			continue
 		}
-		numTestCases += tg.CountTestCases()
-		numSkipped += tg.CountSkipped()
-		numFailed += tg.CountFailed()
+
+		regular = true
+		if err := checkRegularHeaderField(hf); err != nil {
+			return err
+		}
 	}
 
-	numPassed := numTestCases - numSkipped - numFailed
+	switch status {
+	case 0:
+		return fmt.Errorf(":status is missing")
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf(":status appears %d times", status)
+	}
+}
+
+// CheckTrailer returns an error describing why hl is not a well-formed
+// trailer part (RFC 7540, 8.1), or nil.
+func CheckTrailer(hl []hpack.HeaderField) error {
+	for _, hf := range hl {
+		if strings.HasPrefix(hf.Name, ":") {
+			return fmt.Errorf("pseudo-header field %q in trailers", hf.Name)
+		}
+		if err := checkRegularHeaderField(hf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkRegularHeaderField(hf hpack.HeaderField) error {
+	if hf.Name != strings.ToLower(hf.Name) {
+		return fmt.Errorf("header field name %q is not lowercase", hf.Name)
+	}
+
+	for _, name := range connectionSpecificHeaders {
+		if hf.Name == name {
+			return fmt.Errorf("connection-specific header field %q", hf.Name)
+		}
+	}
+	if hf.Name == "te" && hf.Value != "trailers" {
+		return fmt.Errorf("te header field with %q", hf.Value)
+	}
+
+	return nil
+}
+
+// printSummary prints out the test summary of all tests performed.
+func printSummary(ctx *Context, report *Report) {
+	summary := fmt.Sprintf("%v tests, %v passed, %v skipped, %v failed", report.Tests, report.Passed, report.Skipped, report.Failed)
+	if report.Errors > 0 {
+		summary += fmt.Sprintf(", %v errors", report.Errors)
+	}
+	if report.Advisories > 0 {
+		summary += fmt.Sprintf(", %v advisories", report.Advisories)
+	}
 
 	logger.SetColor("gray")
-	logger.Write("%v tests, %v passed, %v skipped, %v failed\n", numTestCases, numPassed, numSkipped, numFailed)
+	if report.Target.Protocol != "" {
+		logger.Write("Negotiated protocol: %s\n", report.Target.Protocol)
+	}
+	logger.Write("%s\n", summary)
 	logger.ResetColor()
 
-	if numFailed == 0 {
+	if report.Advisories > 0 {
+		logger.WriteBlank()
+		logger.SetColor("yellow")
+		logger.Write("===============================================================================\n")
+		logger.Write("Advisories\n")
+		logger.Write("===============================================================================\n")
+		logger.WriteBlank()
+		logger.ResetColor()
+
+		for _, tg := range report.Groups {
+			tg.PrintAdvisoryTestCase(ctx)
+		}
+	}
+
+	if report.Pass() {
 		logger.SetColor("gray")
 		logger.Write("All tests passed\n")
 		logger.ResetColor()
@@ -969,76 +1787,55 @@ func printSummary(ctx *Context, groups []*TestGroup) {
 		logger.WriteBlank()
 		logger.ResetColor()
 
-		for _, tg := range groups {
-			if tg != nil {
-				tg.PrintFailedTestCase(ctx)
-			}
+		for _, tg := range report.Groups {
+			tg.PrintFailedTestCase(ctx)
 		}
 	}
 }
 
-func processTestGroupJUnit(index *int, tg *TestGroup) string {
-	var fileContent string
-
-	fileContent += "<testsuite name=\"" + tg.Section + " " + tg.Name + "\""
-	fileContent += " package=\"" + tg.Section + " " + tg.Name + "\""
-	fileContent += " id=\"" + strconv.Itoa(*index) + "\""
-	fileContent += " tests=\"" + strconv.Itoa(tg.numTestCases) + "\""
-	fileContent += " skipped=\"" + strconv.Itoa(tg.numSkipped) + "\""
-	fileContent += " failures=\"" + strconv.Itoa(tg.numFailed) + "\""
-	fileContent += " errors=\"0\""
-	fileContent += ">"
-	for _, tc := range tg.testCases {
-		fileContent += "<testcase classname=\"" + tg.Section + " " + tg.Name + "\""
-		fileContent += " name=\"" + strings.Replace(tc.Desc, "\"", "'", -1) + "\""
-		fileContent += " time=\"" + tc.testTime.String() + "\">"
-		if tc.failed {
-			fileContent += "<failure message='" + tc.actual.String() + "'>Expected:\n"
-			for _, element := range tc.expected {
-				fileContent += element.String() + "\n"
-			}
-			fileContent += "Actual:\n" + tc.actual.String()
-			fileContent += "</failure>"
-		} else if tc.skipped {
-			fileContent += "<skipped/>"
+// runParallel executes the test cases of groups on a pool of
+// ctx.Parallel workers.  Results are printed later, in section order,
+// by TestGroup.Run.
+func runParallel(ctx *Context, groups []*TestGroup) {
+	var testCases []*TestCase
+	for _, group := range groups {
+		if group != nil {
+			testCases = append(testCases, group.schedule(ctx)...)
 		}
-		fileContent += "</testcase><system-out/><system-err/>"
 	}
-	fileContent += "</testsuite>"
-
-	// There are also subgroups that must be processed too - let's do it regularly
-	for _, testSubGroup := range tg.testGroups {
-		fileContent += processTestGroupJUnit(index, testSubGroup)
-		*index++
-	}
-
-	return fileContent
-}
-
-func printSummaryJUnit(ctx *Context, groups []*TestGroup, jUnitReport string) {
-	var fileContent string
-	fileContent = "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<testsuites>"
 
-	var index = 0
-	for _, tg := range groups {
-		if tg == nil {
-			continue
+	jobs := make(chan *TestCase)
+	go func() {
+		for _, tc := range testCases {
+			jobs <- tc
 		}
-		fileContent += processTestGroupJUnit(&index, tg)
-		index++
-	}
+		close(jobs)
+	}()
 
-	fileContent += "</testsuites>"
-	err := ioutil.WriteFile(jUnitReport, []byte(fileContent), 0644)
-	if err != nil {
-		panic(err)
+	for i := 0; i < ctx.Parallel; i++ {
+		go func() {
+			for tc := range jobs {
+				tc.execute(ctx)
+			}
+		}()
 	}
 }
 
-func Run(ctx *Context) bool {
-	pass := true
+// Run runs all test groups against the target server described by
+// ctx and returns a report of the results.  A non-nil error is
+// returned only if the report could not be written.
+func Run(ctx *Context) (*Report, error) {
+	if ctx.Tls {
+		err := ctx.loadTlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		defer ctx.closeKeyLog()
+	}
 
 	groups := []*TestGroup{
+		StartingHttp2ForHttpUrisTestGroup(ctx),
+		StartingHttp2ForHttpsUrisTestGroup(ctx),
 		Http2ConnectionPrefaceTestGroup(ctx),
 		FrameSizeTestGroup(ctx),
 		HeaderCompressionAndDecompressionTestGroup(ctx),
@@ -1057,18 +1854,65 @@ func Run(ctx *Context) bool {
 		ContinuationTestGroup(ctx),
 		HttpRequestResponseExchangeTestGroup(ctx),
 		ServerPushTestGroup(ctx),
+		UseOfTlsFeaturesTestGroup(ctx),
+		HpackTestGroup(ctx),
+		DosResilienceTestGroup(ctx),
+	}
+
+	if ctx.Scenarios != "" {
+		scenarios, err := LoadScenarios(ctx, ctx.Scenarios)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, scenarios...)
+	}
+
+	return runTestGroups(ctx, groups)
+}
+
+// runTestGroups runs groups, prints the summary and writes the reports
+// requested by ctx.
+func runTestGroups(ctx *Context, groups []*TestGroup) (*Report, error) {
+	if ctx.Pcap != "" {
+		pw, err := createPcap(ctx.Pcap)
+		if err != nil {
+			return nil, err
+		}
+		ctx.pcap = pw
+		defer func() {
+			pw.Close()
+			ctx.pcap = nil
+		}()
+	}
+
+	report := NewReport(ctx)
+	ctx.report = report
+
+	if ctx.Parallel > 1 {
+		runParallel(ctx, groups)
 	}
 
 	for _, group := range groups {
 		if group != nil {
-			pass = group.Run(ctx)
+			group.Run(ctx)
 		}
 	}
 
-	printSummary(ctx, groups)
+	report.Collect(groups)
+
+	printSummary(ctx, report)
 	if ctx.Junit != "" {
-		printSummaryJUnit(ctx, groups, ctx.Junit)
+		err := report.WriteJUnit(ctx.Junit)
+		if err != nil {
+			return report, err
+		}
+	}
+	if ctx.Json != "" {
+		err := report.WriteJSON(ctx.Json)
+		if err != nil {
+			return report, err
+		}
 	}
 
-	return pass
+	return report, nil
 }
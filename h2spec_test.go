@@ -0,0 +1,65 @@
+package h2spec
+
+import "testing"
+
+func TestGetRunMode(t *testing.T) {
+	// as set by -s 6.5 -s dos -s hpack/5.1
+	ctx := &Context{Sections: map[string]bool{
+		"6.5":       true,
+		"dos":       true,
+		"hpack":     false,
+		"hpack/5.1": true,
+	}}
+
+	tests := []struct {
+		section string
+		want    RunMode
+	}{
+		{"6.5", ModeAll},
+		{"6.5.3", ModeSkip},
+		{"6.9", ModeSkip},
+		{"dos", ModeAll},
+		{"dos/rapid-reset", ModeAll},
+		{"hpack", ModeGroupOnly},
+		{"hpack/5.1", ModeAll},
+		{"hpack/5.2", ModeSkip},
+		{"scenario/dos", ModeSkip},
+	}
+
+	for _, tt := range tests {
+		if got := ctx.GetRunMode(tt.section); got != tt.want {
+			t.Errorf("GetRunMode(%q) = %v, want %v", tt.section, got, tt.want)
+		}
+	}
+
+	if got := (&Context{}).GetRunMode("dos/rapid-reset"); got != ModeAll {
+		t.Errorf("GetRunMode without sections = %v, want %v", got, ModeAll)
+	}
+}
+
+func TestTestCaseRunAgain(t *testing.T) {
+	runs := 0
+	tg := NewTestGroup("6.5", "SETTINGS")
+	tg.AddTestCase(NewTestCase("counts its runs", "", func(ctx *Context) (pass bool, expected []Result, actual Result) {
+		runs++
+		return runs != 2, nil, &ResultTestTimeout{}
+	}))
+	tc := tg.testCases[0]
+
+	ctx := &Context{Parallel: 1}
+	runParallel(ctx, []*TestGroup{tg})
+	if got := tc.Run(ctx); got != Passed || runs != 1 {
+		t.Fatalf("scheduled run: got %v after %d runs, want passed after 1", got, runs)
+	}
+
+	// a run which is not scheduled executes the handler again instead
+	// of returning the result of the scheduled one.
+	if got := tc.Run(ctx); got != Failed || runs != 2 {
+		t.Errorf("second run: got %v after %d runs, want failed after 2", got, runs)
+	}
+
+	runParallel(ctx, []*TestGroup{tg})
+	if got := tc.Run(ctx); got != Passed || runs != 3 {
+		t.Errorf("rescheduled run: got %v after %d runs, want passed after 3", got, runs)
+	}
+}
@@ -0,0 +1,102 @@
+package h2spec
+
+import (
+	"golang.org/x/net/http2/hpack"
+)
+
+// hpackBlock builds a header block fragment from raw HPACK
+// representations (RFC 7541, section 6).  Unlike hpack.Encoder, it
+// does not validate anything and does not track the dynamic table, so
+// that invalid encodings can be sent to the target.
+type hpackBlock struct {
+	buf []byte
+}
+
+// Integer appends the integer i with an n-bit prefix (RFC 7541, 5.1).
+// first holds the bits of the first octet that precede the prefix.
+func (b *hpackBlock) Integer(first byte, n uint8, i uint64) *hpackBlock {
+	max := uint64(1)<<n - 1
+	if i < max {
+		b.buf = append(b.buf, first|byte(i))
+		return b
+	}
+
+	b.buf = append(b.buf, first|byte(max))
+	i -= max
+	for i >= 128 {
+		b.buf = append(b.buf, byte(i&0x7f|0x80))
+		i >>= 7
+	}
+	b.buf = append(b.buf, byte(i))
+
+	return b
+}
+
+// String appends s as a string literal (RFC 7541, 5.2), Huffman
+// encoded if huffman is true.
+func (b *hpackBlock) String(s string, huffman bool) *hpackBlock {
+	if huffman {
+		data := hpack.AppendHuffmanString(nil, s)
+		return b.RawString(true, uint64(len(data)), data)
+	}
+	return b.RawString(false, uint64(len(s)), []byte(s))
+}
+
+// RawString appends a string literal whose length and data are given
+// separately, so that the length may not match the data.
+func (b *hpackBlock) RawString(huffman bool, length uint64, data []byte) *hpackBlock {
+	var first byte
+	if huffman {
+		first = 0x80
+	}
+	b.Integer(first, 7, length)
+	b.buf = append(b.buf, data...)
+	return b
+}
+
+// Indexed appends an indexed header field (RFC 7541, 6.1).
+func (b *hpackBlock) Indexed(index uint64) *hpackBlock {
+	return b.Integer(0x80, 7, index)
+}
+
+// LiteralIndexed appends a literal header field with incremental
+// indexing (RFC 7541, 6.2.1).  A nameIndex of 0 means a new name.
+func (b *hpackBlock) LiteralIndexed(nameIndex uint64, name, value string) *hpackBlock {
+	return b.literal(0x40, 6, nameIndex, name, value)
+}
+
+// LiteralWithoutIndexing appends a literal header field without
+// indexing (RFC 7541, 6.2.2).  A nameIndex of 0 means a new name.
+func (b *hpackBlock) LiteralWithoutIndexing(nameIndex uint64, name, value string) *hpackBlock {
+	return b.literal(0x00, 4, nameIndex, name, value)
+}
+
+// LiteralNeverIndexed appends a literal header field never indexed
+// (RFC 7541, 6.2.3).  A nameIndex of 0 means a new name.
+func (b *hpackBlock) LiteralNeverIndexed(nameIndex uint64, name, value string) *hpackBlock {
+	return b.literal(0x10, 4, nameIndex, name, value)
+}
+
+func (b *hpackBlock) literal(first byte, n uint8, nameIndex uint64, name, value string) *hpackBlock {
+	b.Integer(first, n, nameIndex)
+	if nameIndex == 0 {
+		b.String(name, false)
+	}
+	return b.String(value, false)
+}
+
+// SizeUpdate appends a dynamic table size update (RFC 7541, 6.3).
+func (b *hpackBlock) SizeUpdate(size uint64) *hpackBlock {
+	return b.Integer(0x20, 5, size)
+}
+
+// Raw appends data as is.
+func (b *hpackBlock) Raw(data ...byte) *hpackBlock {
+	b.buf = append(b.buf, data...)
+	return b
+}
+
+// Bytes returns the header block fragment built so far.
+func (b *hpackBlock) Bytes() []byte {
+	return b.buf
+}
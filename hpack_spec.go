@@ -0,0 +1,303 @@
+package h2spec
+
+import (
+	"golang.org/x/net/http2"
+)
+
+// The test groups in this file cover HPACK: Header Compression for
+// HTTP/2 (RFC 7541).  Their sections are prefixed with "hpack/" to be
+// told apart from the sections of RFC 7540.
+
+func HpackTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("hpack", "HPACK: Header Compression for HTTP/2 (RFC 7541)")
+
+	tg.AddTestGroup(HpackIndexAddressSpaceTestGroup(ctx))
+	tg.AddTestGroup(HpackMaximumTableSizeTestGroup(ctx))
+	tg.AddTestGroup(HpackIntegerRepresentationTestGroup(ctx))
+	tg.AddTestGroup(HpackStringLiteralRepresentationTestGroup(ctx))
+	tg.AddTestGroup(HpackLiteralNeverIndexedTestGroup(ctx))
+
+	return tg
+}
+
+func HpackIndexAddressSpaceTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("hpack/2.3.3", "Index Address Space")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an indexed header field representation with index 0",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			block := new(hpackBlock).Indexed(0)
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an indexed header field representation with index greater than the size of the table",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			// The static table has 61 entries and nothing has
+			// been added to the dynamic table yet.
+			block := new(hpackBlock).Indexed(70)
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a literal header field representation with indexed name greater than the size of the table",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			block := new(hpackBlock).LiteralIndexed(70, "", "test")
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	return tg
+}
+
+func HpackMaximumTableSizeTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("hpack/4.2", "Maximum Table Size")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a dynamic table size update larger than the value of SETTINGS_HEADER_TABLE_SIZE",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			tableSize, ok := http2Conn.Settings[http2.SettingHeaderTableSize]
+			if !ok {
+				tableSize = 4096
+			}
+
+			block := new(hpackBlock).SizeUpdate(uint64(tableSize) + 1)
+			return writeHpackBlock(ctx, http2Conn, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a dynamic table size update at the end of header block",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			block := new(hpackBlock)
+			block.Raw(http2Conn.EncodeHeader(commonHeaderFields(ctx))...)
+			block.SizeUpdate(0)
+
+			return writeHpackBlockOnly(ctx, http2Conn, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a dynamic table size update between header field representations",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			hdrs := commonHeaderFields(ctx)
+
+			block := new(hpackBlock)
+			block.Raw(http2Conn.EncodeHeader(hdrs[:2])...)
+			block.SizeUpdate(0)
+			block.Raw(http2Conn.EncodeHeader(hdrs[2:])...)
+
+			return writeHpackBlockOnly(ctx, http2Conn, block)
+		},
+	))
+
+	return tg
+}
+
+func HpackIntegerRepresentationTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("hpack/5.1", "Integer Representation")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends an indexed header field representation with the integer that overflows",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			// An index encoded in 10 continuation octets,
+			// which is larger than 2^63.
+			block := new(hpackBlock).Raw(0xff)
+			for i := 0; i < 10; i++ {
+				block.Raw(0xff)
+			}
+			block.Raw(0x01)
+
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a string literal with the length that overflows",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			block := new(hpackBlock).Raw(0x00, 0x7f)
+			for i := 0; i < 10; i++ {
+				block.Raw(0xff)
+			}
+			block.Raw(0x01)
+
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	return tg
+}
+
+func HpackStringLiteralRepresentationTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("hpack/5.2", "String Literal Representation")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a Huffman-encoded string literal containing the EOS symbol",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			// "a" (00011), EOS (30 bits of 1) and 5 bits of
+			// padding.
+			data := []byte{0x1f, 0xff, 0xff, 0xff, 0xff}
+
+			block := new(hpackBlock).Raw(0x00).String("x-test", false)
+			block.RawString(true, uint64(len(data)), data)
+
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a Huffman-encoded string literal with padding longer than 7 bits",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			// "a" (00011) and 11 bits of padding.
+			data := []byte{0x1f, 0xff}
+
+			block := new(hpackBlock).Raw(0x00).String("x-test", false)
+			block.RawString(true, uint64(len(data)), data)
+
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a Huffman-encoded string literal with padding not corresponding to the EOS symbol",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			// "a" (00011) and 3 bits of padding filled with 0.
+			data := []byte{0x18}
+
+			block := new(hpackBlock).Raw(0x00).String("x-test", false)
+			block.RawString(true, uint64(len(data)), data)
+
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a string literal with the length exceeding the header block",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			// The length of the value says 10 octets but the
+			// header block ends after 4 octets.
+			block := new(hpackBlock)
+			block.Raw(http2Conn.EncodeHeader(commonHeaderFields(ctx))...)
+			block.Raw(0x00).String("x-test", false)
+			block.RawString(false, 10, []byte("test"))
+
+			return writeHpackBlockOnly(ctx, http2Conn, block)
+		},
+	))
+
+	return tg
+}
+
+func HpackLiteralNeverIndexedTestGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup("hpack/6.2.3", "Literal Header Field Never Indexed")
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a literal header field never indexed representation",
+		"The endpoint MUST accept literal header field never indexed representation.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			http2Conn, err := CreateHttp2Conn(ctx, true)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			defer http2Conn.conn.Close()
+
+			streamID := http2Conn.NextStreamID()
+
+			block := new(hpackBlock)
+			block.Raw(http2Conn.EncodeHeader(commonHeaderFields(ctx))...)
+			block.LiteralNeverIndexed(0, "x-test", "test")
+
+			var hp http2.HeadersFrameParam
+			hp.StreamID = streamID
+			hp.EndStream = true
+			hp.EndHeaders = true
+			hp.BlockFragment = block.Bytes()
+			http2Conn.fr.WriteHeaders(hp)
+
+			return TestStreamClose(ctx, http2Conn)
+		},
+	))
+
+	tg.AddTestCase(NewTestCase(
+		"Sends a literal header field never indexed representation with indexed name greater than the size of the table",
+		"The endpoint MUST treat this as a decoding error.",
+		func(ctx *Context) (pass bool, expected []Result, actual Result) {
+			block := new(hpackBlock).LiteralNeverIndexed(70, "", "test")
+			return testHpackDecodingError(ctx, block)
+		},
+	))
+
+	return tg
+}
+
+// testHpackDecodingError sends a request whose header block starts with
+// block, and expects a connection error of type COMPRESSION_ERROR.
+func testHpackDecodingError(ctx *Context, block *hpackBlock) (pass bool, expected []Result, actual Result) {
+	http2Conn, err := CreateHttp2Conn(ctx, true)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer http2Conn.conn.Close()
+
+	return writeHpackBlock(ctx, http2Conn, block)
+}
+
+// writeHpackBlock sends a request whose header block is block followed
+// by the common header fields, and expects a connection error of type
+// COMPRESSION_ERROR.
+func writeHpackBlock(ctx *Context, http2Conn *Http2Conn, block *hpackBlock) (pass bool, expected []Result, actual Result) {
+	block.Raw(http2Conn.EncodeHeader(commonHeaderFields(ctx))...)
+	return writeHpackBlockOnly(ctx, http2Conn, block)
+}
+
+// writeHpackBlockOnly sends a request whose header block is block, and
+// expects a connection error of type COMPRESSION_ERROR.
+func writeHpackBlockOnly(ctx *Context, http2Conn *Http2Conn, block *hpackBlock) (pass bool, expected []Result, actual Result) {
+	var hp http2.HeadersFrameParam
+	hp.StreamID = http2Conn.NextStreamID()
+	hp.EndStream = true
+	hp.EndHeaders = true
+	hp.BlockFragment = block.Bytes()
+	http2Conn.fr.WriteHeaders(hp)
+
+	actualCodes := []http2.ErrCode{http2.ErrCodeCompression}
+	return TestConnectionError(ctx, http2Conn, actualCodes)
+}
@@ -0,0 +1,142 @@
+package h2spec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestHpackBlockInteger(t *testing.T) {
+	tests := []struct {
+		first byte
+		n     uint8
+		i     uint64
+		want  string
+	}{
+		// RFC 7541, C.1.
+		{0x00, 5, 10, "0a"},
+		{0x00, 5, 1337, "1f9a0a"},
+		{0x00, 8, 42, "2a"},
+		// the prefix is filled up and followed by a zero octet.
+		{0x00, 5, 31, "1f00"},
+		{0x00, 5, 30, "1e"},
+		{0x00, 7, 127 + 128, "7f8001"},
+		// the bits before the prefix are kept.
+		{0xe0, 5, 10, "ea"},
+		{0x80, 7, 200, "ff49"},
+	}
+
+	for _, tt := range tests {
+		got := new(hpackBlock).Integer(tt.first, tt.n, tt.i).Bytes()
+		if want := mustHex(t, tt.want); !bytes.Equal(got, want) {
+			t.Errorf("Integer(%#x, %d, %d) = %x, want %s", tt.first, tt.n, tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestHpackBlockRepresentations(t *testing.T) {
+	tests := []struct {
+		name  string
+		block *hpackBlock
+		want  string
+	}{
+		// RFC 7541, C.2.1 to C.2.4.
+		{
+			"literal with indexing",
+			new(hpackBlock).LiteralIndexed(0, "custom-key", "custom-header"),
+			"400a637573746f6d2d6b65790d637573746f6d2d686561646572",
+		},
+		{
+			"literal without indexing",
+			new(hpackBlock).LiteralWithoutIndexing(4, "", "/sample/path"),
+			"040c2f73616d706c652f70617468",
+		},
+		{
+			"literal never indexed",
+			new(hpackBlock).LiteralNeverIndexed(0, "password", "secret"),
+			"100870617373776f726406736563726574",
+		},
+		{
+			"indexed",
+			new(hpackBlock).Indexed(2),
+			"82",
+		},
+		// RFC 7541, C.4.1.
+		{
+			"Huffman encoded string",
+			new(hpackBlock).String("www.example.com", true),
+			"8cf1e3c2e5f23a6ba0ab90f4ff",
+		},
+		{
+			"size update",
+			new(hpackBlock).SizeUpdate(4096),
+			"3fe11f",
+		},
+		{
+			"string length not matching the data",
+			new(hpackBlock).RawString(false, 10, []byte("abc")),
+			"0a616263",
+		},
+		{
+			"chained representations",
+			new(hpackBlock).SizeUpdate(0).Indexed(2).Raw(0xff),
+			"2082ff",
+		},
+	}
+
+	for _, tt := range tests {
+		if want := mustHex(t, tt.want); !bytes.Equal(tt.block.Bytes(), want) {
+			t.Errorf("%s: got %x, want %s", tt.name, tt.block.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestHpackBlockDecodes(t *testing.T) {
+	block := new(hpackBlock).
+		Indexed(2).
+		LiteralIndexed(1, "", "example.com").
+		LiteralWithoutIndexing(0, "x-h2spec", "1").
+		LiteralNeverIndexed(0, "x-secret", "2").
+		Indexed(62)
+
+	var fields []hpack.HeaderField
+	dec := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		fields = append(fields, f)
+	})
+	_, err := dec.Write(block.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: "x-h2spec", Value: "1"},
+		{Name: "x-secret", Value: "2", Sensitive: true},
+		{Name: ":authority", Value: "example.com"},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("got %d fields, want %d", len(fields), len(want))
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("field %d: got %v, want %v", i, fields[i], want[i])
+		}
+	}
+}
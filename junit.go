@@ -0,0 +1,165 @@
+package h2spec
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Package    string           `xml:"package,attr"`
+	ID         int              `xml:"id,attr"`
+	Hostname   string           `xml:"hostname,attr"`
+	Timestamp  string           `xml:"timestamp,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties []junitProperty  `xml:"properties>property"`
+	TestCases  []*junitTestCase `xml:"testcase"`
+	SystemOut  string           `xml:"system-out"`
+	SystemErr  string           `xml:"system-err"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// junitSeconds formats d as the number of seconds expected by the
+// time attributes of JUnit reports.
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// junitDetail describes the expected and actual results of a test
+// case in the body of a failure or error element.
+func junitDetail(tc *TestCase) string {
+	lines := []string{tc.Spec, "Expected:"}
+	for _, exp := range tc.expected {
+		lines = append(lines, "  "+exp.String())
+	}
+	lines = append(lines, "Actual:", "  "+tc.actual.String())
+
+	return strings.Join(lines, "\n")
+}
+
+func (r *Report) junitTestSuites(tg *TestGroup, hostname string, suites []*junitTestSuite) []*junitTestSuite {
+	name := tg.Section + " " + tg.Name
+
+	suite := &junitTestSuite{
+		Name:      name,
+		Package:   name,
+		ID:        len(suites),
+		Hostname:  hostname,
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+		Properties: []junitProperty{
+			{"target", fmt.Sprintf("%s:%d", r.Target.Host, r.Target.Port)},
+			{"tls", strconv.FormatBool(r.Target.Tls)},
+			{"strict", strconv.FormatBool(r.Strict)},
+		},
+	}
+	suites = append(suites, suite)
+
+	var total time.Duration
+	for _, tc := range tg.testCases {
+		jtc := &junitTestCase{
+			ClassName: name,
+			Name:      tc.Desc,
+			Time:      junitSeconds(tc.testTime),
+		}
+
+		switch tc.Result() {
+		case Failed:
+			jtc.Failure = &junitFailure{
+				Message: tc.actual.String(),
+				Type:    "failure",
+				Text:    junitDetail(tc),
+			}
+			suite.Failures++
+		case Errored:
+			jtc.Error = &junitFailure{
+				Message: tc.actual.String(),
+				Type:    "error",
+				Text:    junitDetail(tc),
+			}
+			suite.Errors++
+		case Advisory:
+			// JUnit has no notion of advisories, so they are
+			// reported as passed test cases with the details.
+			jtc.SystemOut = "Advisory: " + junitDetail(tc)
+		case Skipped:
+			jtc.Skipped = &junitSkipped{}
+			if tc.actual != nil {
+				jtc.Skipped.Message = tc.actual.String()
+			}
+			suite.Skipped++
+		}
+
+		total += tc.testTime
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, jtc)
+	}
+	suite.Time = junitSeconds(total)
+
+	for _, child := range tg.testGroups {
+		suites = r.junitTestSuites(child, hostname, suites)
+	}
+
+	return suites
+}
+
+// WriteJUnit writes the report in JUnit XML format to the file at
+// path.  Each test group becomes a testsuite element.
+func (r *Report) WriteJUnit(path string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	var suites []*junitTestSuite
+	for _, tg := range r.Groups {
+		suites = r.junitTestSuites(tg, hostname, suites)
+	}
+
+	data, err := xml.MarshalIndent(&junitTestSuites{Suites: suites}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data = append([]byte(xml.Header), data...)
+	data = append(data, '\n')
+
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,152 @@
+package h2spec
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// junitTestReport returns a report of a run with a test case of every
+// result, the nested group holding the skipped one.
+func junitTestReport() *Report {
+	expected := []Result{&ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeProtocol}}
+
+	tg := NewTestGroup("6.5", "SETTINGS")
+	for _, tc := range []*TestCase{
+		{Desc: "passes", result: Passed, testTime: 250 * time.Millisecond},
+		{Desc: "fails", Spec: "The endpoint MUST respond.", result: Failed, expected: expected, actual: &ResultTestTimeout{}, testTime: time.Second},
+		{Desc: "errors", result: Errored, expected: expected, actual: &ResultError{errors.New("broken pipe")}},
+		{Desc: "advises", result: Advisory, expected: expected, actual: &ResultConnectionClose{}},
+	} {
+		tg.AddTestCase(tc)
+	}
+
+	child := NewTestGroup("6.5.3", "Settings Synchronization")
+	child.AddTestCase(&TestCase{Desc: "is skipped", skipped: true, actual: &ResultSkipped{"not supported"}})
+	tg.AddTestGroup(child)
+
+	return &Report{
+		Target:    &Target{Host: "127.0.0.1", Port: 8443, Tls: true},
+		StartedAt: time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC),
+		Groups:    []*TestGroup{tg},
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.xml")
+	err := junitTestReport().WriteJUnit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("XML declaration is missing")
+	}
+
+	var report junitTestSuites
+	err = xml.Unmarshal(data, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Suites) != 2 {
+		t.Fatalf("got %d test suites, want 2", len(report.Suites))
+	}
+	suite, child := report.Suites[0], report.Suites[1]
+
+	if suite.Name != "6.5 SETTINGS" || child.Name != "6.5.3 Settings Synchronization" {
+		t.Errorf("suite names: got %q and %q", suite.Name, child.Name)
+	}
+	if suite.ID != 0 || child.ID != 1 {
+		t.Errorf("suite ids: got %d and %d, want 0 and 1", suite.ID, child.ID)
+	}
+	if suite.Timestamp != "2026-10-17T09:30:00" {
+		t.Errorf("timestamp: got %q", suite.Timestamp)
+	}
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 0 {
+		t.Errorf("counts: got %d tests, %d failures, %d errors, %d skipped, want 4, 1, 1, 0",
+			suite.Tests, suite.Failures, suite.Errors, suite.Skipped)
+	}
+	if child.Tests != 1 || child.Skipped != 1 {
+		t.Errorf("counts of the nested suite: got %d tests, %d skipped, want 1, 1", child.Tests, child.Skipped)
+	}
+	if suite.Time != "1.250" {
+		t.Errorf("time: got %q, want \"1.250\"", suite.Time)
+	}
+
+	properties := map[string]string{}
+	for _, p := range suite.Properties {
+		properties[p.Name] = p.Value
+	}
+	if properties["target"] != "127.0.0.1:8443" || properties["tls"] != "true" || properties["strict"] != "false" {
+		t.Errorf("properties: got %v", properties)
+	}
+
+	passed, failed, errored, advisory := suite.TestCases[0], suite.TestCases[1], suite.TestCases[2], suite.TestCases[3]
+
+	if passed.Failure != nil || passed.Error != nil || passed.Skipped != nil || passed.SystemOut != "" {
+		t.Errorf("passed test case carries a result element")
+	}
+	if passed.ClassName != "6.5 SETTINGS" || passed.Name != "passes" || passed.Time != "0.250" {
+		t.Errorf("passed test case: got %q, %q, %q", passed.ClassName, passed.Name, passed.Time)
+	}
+
+	timeout := (&ResultTestTimeout{}).String()
+	if failed.Failure == nil {
+		t.Fatalf("failure element is missing")
+	}
+	if failed.Failure.Message != timeout || failed.Failure.Type != "failure" {
+		t.Errorf("failure: got message %q and type %q", failed.Failure.Message, failed.Failure.Type)
+	}
+	for _, s := range []string{"The endpoint MUST respond.", "Expected:", "GOAWAY", "Actual:", timeout} {
+		if !strings.Contains(failed.Failure.Text, s) {
+			t.Errorf("failure detail does not contain %q:\n%s", s, failed.Failure.Text)
+		}
+	}
+
+	if errored.Error == nil || errored.Error.Type != "error" || !strings.Contains(errored.Error.Message, "broken pipe") {
+		t.Errorf("error element: got %+v", errored.Error)
+	}
+	if errored.Failure != nil {
+		t.Errorf("errored test case carries a failure element")
+	}
+
+	if advisory.Failure != nil || advisory.Error != nil {
+		t.Errorf("advisory is reported as a failure or an error")
+	}
+	if !strings.HasPrefix(advisory.SystemOut, "Advisory: ") {
+		t.Errorf("advisory system-out: got %q", advisory.SystemOut)
+	}
+
+	skipped := child.TestCases[0]
+	if skipped.Skipped == nil || skipped.Skipped.Message != (&ResultSkipped{"not supported"}).String() {
+		t.Errorf("skipped element: got %+v", skipped.Skipped)
+	}
+}
+
+func TestJunitSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0.000"},
+		{1500 * time.Microsecond, "0.002"},
+		{2*time.Second + 345*time.Millisecond, "2.345"},
+	}
+
+	for _, tt := range tests {
+		if got := junitSeconds(tt.d); got != tt.want {
+			t.Errorf("junitSeconds(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
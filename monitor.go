@@ -0,0 +1,337 @@
+package h2spec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// frameSplitter splits a byte stream into HTTP/2 frames and calls fn
+// with the header and payload of each complete frame.
+type frameSplitter struct {
+	buf []byte
+	fn  func(fh http2.FrameHeader, payload []byte)
+}
+
+func (fs *frameSplitter) Write(p []byte) (int, error) {
+	fs.buf = append(fs.buf, p...)
+	for len(fs.buf) >= 9 {
+		length := int(fs.buf[0])<<16 | int(fs.buf[1])<<8 | int(fs.buf[2])
+		if len(fs.buf) < 9+length {
+			break
+		}
+
+		fh := http2.FrameHeader{
+			Type:     http2.FrameType(fs.buf[3]),
+			Flags:    http2.Flags(fs.buf[4]),
+			Length:   uint32(length),
+			StreamID: binary.BigEndian.Uint32(fs.buf[5:9]) & (1<<31 - 1),
+		}
+		fs.fn(fh, fs.buf[9:9+length])
+
+		fs.buf = fs.buf[9+length:]
+	}
+
+	return len(p), nil
+}
+
+// ResultViolation describes a frame received from the endpoint which
+// breaks a rule of RFC 7540 regardless of the test case.
+type ResultViolation struct {
+	Type     http2.FrameType
+	StreamID uint32
+	Reason   string
+	Warning  bool // true if the frame is suspicious rather than illegal
+}
+
+func (rv *ResultViolation) String() string {
+	kind := "Protocol violation"
+	if rv.Warning {
+		kind = "Warning"
+	}
+	return fmt.Sprintf("%s: %s frame on stream %d (%s)", kind, rv.Type, rv.StreamID, rv.Reason)
+}
+
+// frameMonitor validates every frame received from the endpoint under
+// test against the rules of RFC 7540 which do not depend on the stream
+// state, including the frames which http2.Framer refuses to parse.
+// Frames sent by h2spec are watched to know the settings in effect.
+type frameMonitor struct {
+	mu             sync.Mutex
+	server         bool            // true if the local endpoint is the server
+	maxFrameSize   uint32          // the largest SETTINGS_MAX_FRAME_SIZE sent
+	pushDisabled   bool            // true if SETTINGS_ENABLE_PUSH of 0 was sent
+	settingsSent   int             // SETTINGS frames sent and not acknowledged
+	pingsSent      map[[8]byte]int // PING frames sent and not acknowledged
+	headerStreamID uint32          // the stream of the header block in progress
+	goAwaySeen     bool
+	lastStreamID   uint32 // the last stream ID of the last GOAWAY frame
+	violations     []*ResultViolation
+	sent           *frameSplitter
+	recv           *frameSplitter
+}
+
+func newFrameMonitor() *frameMonitor {
+	m := &frameMonitor{
+		maxFrameSize: 16384,
+		pingsSent:    map[[8]byte]int{},
+	}
+	m.sent = &frameSplitter{fn: m.checkSent}
+	m.recv = &frameSplitter{fn: m.checkRecv}
+	return m
+}
+
+// Write takes the bytes read from the connection after the connection
+// preface.
+func (m *frameMonitor) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recv.Write(p)
+}
+
+// sentMonitor takes the bytes written to the connection after the
+// connection preface for a frameMonitor.
+type sentMonitor struct {
+	m *frameMonitor
+}
+
+func (sm sentMonitor) Write(p []byte) (int, error) {
+	sm.m.mu.Lock()
+	defer sm.m.mu.Unlock()
+
+	return sm.m.sent.Write(p)
+}
+
+// checkSent keeps the settings and PING frames sent to the endpoint.
+func (m *frameMonitor) checkSent(fh http2.FrameHeader, payload []byte) {
+	switch fh.Type {
+	case http2.FrameSettings:
+		if fh.Flags.Has(http2.FlagSettingsAck) {
+			return
+		}
+		m.settingsSent++
+		for i := 0; i+6 <= len(payload); i += 6 {
+			id := http2.SettingID(binary.BigEndian.Uint16(payload[i:]))
+			val := binary.BigEndian.Uint32(payload[i+2:])
+			switch id {
+			case http2.SettingMaxFrameSize:
+				if val > m.maxFrameSize {
+					m.maxFrameSize = val
+				}
+			case http2.SettingEnablePush:
+				m.pushDisabled = val == 0
+			}
+		}
+	case http2.FramePing:
+		if !fh.Flags.Has(http2.FlagPingAck) && len(payload) == 8 {
+			var data [8]byte
+			copy(data[:], payload)
+			m.pingsSent[data]++
+		}
+	}
+}
+
+func (m *frameMonitor) violate(fh http2.FrameHeader, format string, a ...interface{}) {
+	m.violations = append(m.violations, &ResultViolation{
+		Type:     fh.Type,
+		StreamID: fh.StreamID,
+		Reason:   fmt.Sprintf(format, a...),
+	})
+}
+
+func (m *frameMonitor) warn(fh http2.FrameHeader, format string, a ...interface{}) {
+	m.violate(fh, format, a...)
+	m.violations[len(m.violations)-1].Warning = true
+}
+
+// checkRecv validates a frame received from the endpoint.
+func (m *frameMonitor) checkRecv(fh http2.FrameHeader, payload []byte) {
+	if fh.Length > m.maxFrameSize {
+		m.violate(fh, "length %d exceeds SETTINGS_MAX_FRAME_SIZE of %d", fh.Length, m.maxFrameSize)
+	}
+
+	// a header block must be sent as a contiguous sequence of frames.
+	if m.headerStreamID != 0 && (fh.Type != http2.FrameContinuation || fh.StreamID != m.headerStreamID) {
+		m.violate(fh, "interrupts the header block on stream %d", m.headerStreamID)
+		m.headerStreamID = 0
+		if fh.Type == http2.FrameContinuation {
+			return
+		}
+	}
+
+	switch fh.Type {
+	case http2.FrameData, http2.FrameHeaders, http2.FramePriority, http2.FrameRSTStream,
+		http2.FramePushPromise, http2.FrameContinuation:
+		if fh.StreamID == 0 {
+			m.violate(fh, "must be associated with a stream")
+			return
+		}
+	case http2.FrameSettings, http2.FramePing, http2.FrameGoAway:
+		if fh.StreamID != 0 {
+			m.violate(fh, "must not be associated with a stream")
+			return
+		}
+	}
+
+	switch fh.Type {
+	case http2.FrameData:
+		m.checkPadding(fh, payload, http2.FlagDataPadded)
+	case http2.FrameHeaders:
+		payload = m.checkPadding(fh, payload, http2.FlagHeadersPadded)
+		if fh.Flags.Has(http2.FlagHeadersPriority) {
+			if len(payload) < 5 {
+				m.violate(fh, "too short for the priority fields")
+				return
+			}
+			m.checkDependency(fh, payload)
+		}
+		if !fh.Flags.Has(http2.FlagHeadersEndHeaders) {
+			m.headerStreamID = fh.StreamID
+		}
+	case http2.FramePriority:
+		if fh.Length != 5 {
+			m.violate(fh, "length must be 5")
+			return
+		}
+		m.checkDependency(fh, payload)
+	case http2.FrameRSTStream:
+		if fh.Length != 4 {
+			m.violate(fh, "length must be 4")
+		}
+	case http2.FrameSettings:
+		m.checkSettings(fh, payload)
+	case http2.FramePushPromise:
+		if m.server {
+			m.violate(fh, "clients must not push")
+		} else if m.pushDisabled {
+			m.violate(fh, "server push was disabled by SETTINGS_ENABLE_PUSH")
+		}
+		payload = m.checkPadding(fh, payload, http2.FlagPushPromisePadded)
+		if len(payload) < 4 {
+			m.violate(fh, "too short for the promised stream ID")
+			return
+		}
+		if !fh.Flags.Has(http2.FlagPushPromiseEndHeaders) {
+			m.headerStreamID = fh.StreamID
+		}
+	case http2.FramePing:
+		if fh.Length != 8 {
+			m.violate(fh, "length must be 8")
+			return
+		}
+		if fh.Flags.Has(http2.FlagPingAck) {
+			var data [8]byte
+			copy(data[:], payload)
+			if m.pingsSent[data] == 0 {
+				m.warn(fh, "acknowledges a PING frame which was not sent")
+			} else {
+				m.pingsSent[data]--
+			}
+		}
+	case http2.FrameGoAway:
+		if fh.Length < 8 {
+			m.violate(fh, "too short for the last stream ID and error code")
+			return
+		}
+		lastStreamID := binary.BigEndian.Uint32(payload) & (1<<31 - 1)
+		if m.goAwaySeen && lastStreamID > m.lastStreamID {
+			m.violate(fh, "last stream ID increased from %d to %d", m.lastStreamID, lastStreamID)
+		}
+		m.goAwaySeen = true
+		m.lastStreamID = lastStreamID
+	case http2.FrameWindowUpdate:
+		if fh.Length != 4 {
+			m.violate(fh, "length must be 4")
+			return
+		}
+		if binary.BigEndian.Uint32(payload)&(1<<31-1) == 0 {
+			m.violate(fh, "window size increment of 0")
+		}
+	case http2.FrameContinuation:
+		if m.headerStreamID == 0 {
+			m.violate(fh, "no header block in progress")
+			return
+		}
+		if fh.Flags.Has(http2.FlagContinuationEndHeaders) {
+			m.headerStreamID = 0
+		}
+	}
+}
+
+// checkPadding validates the padding of a frame if flag is set, and
+// returns the payload without the padding.
+func (m *frameMonitor) checkPadding(fh http2.FrameHeader, payload []byte, flag http2.Flags) []byte {
+	if !fh.Flags.Has(flag) {
+		return payload
+	}
+	if len(payload) == 0 {
+		m.violate(fh, "too short for the pad length")
+		return payload
+	}
+
+	padLength := int(payload[0])
+	if padLength >= len(payload) {
+		m.violate(fh, "pad length %d exceeds the payload", padLength)
+		return payload[1:]
+	}
+	return payload[1 : len(payload)-padLength]
+}
+
+// checkDependency validates the stream dependency at the head of
+// payload.
+func (m *frameMonitor) checkDependency(fh http2.FrameHeader, payload []byte) {
+	if binary.BigEndian.Uint32(payload)&(1<<31-1) == fh.StreamID {
+		m.violate(fh, "stream depends on itself")
+	}
+}
+
+// checkSettings validates the values of a SETTINGS frame.
+func (m *frameMonitor) checkSettings(fh http2.FrameHeader, payload []byte) {
+	if fh.Flags.Has(http2.FlagSettingsAck) {
+		if fh.Length != 0 {
+			m.violate(fh, "ACK must have an empty payload")
+		}
+		if m.settingsSent == 0 {
+			m.warn(fh, "acknowledges a SETTINGS frame which was not sent")
+		} else {
+			m.settingsSent--
+		}
+		return
+	}
+
+	if fh.Length%6 != 0 {
+		m.violate(fh, "length must be a multiple of 6")
+		return
+	}
+
+	for i := 0; i < len(payload); i += 6 {
+		id := http2.SettingID(binary.BigEndian.Uint16(payload[i:]))
+		val := binary.BigEndian.Uint32(payload[i+2:])
+		switch id {
+		case http2.SettingEnablePush:
+			if val > 1 {
+				m.violate(fh, "SETTINGS_ENABLE_PUSH of %d", val)
+			}
+		case http2.SettingInitialWindowSize:
+			if val > 1<<31-1 {
+				m.violate(fh, "SETTINGS_INITIAL_WINDOW_SIZE of %d", val)
+			}
+		case http2.SettingMaxFrameSize:
+			if val < 1<<14 || val > 1<<24-1 {
+				m.violate(fh, "SETTINGS_MAX_FRAME_SIZE of %d", val)
+			}
+		}
+	}
+}
+
+// Violations returns the protocol violations found in the frames
+// received so far.
+func (h2Conn *Http2Conn) Violations() []*ResultViolation {
+	h2Conn.monitor.mu.Lock()
+	defer h2Conn.monitor.mu.Unlock()
+
+	return append([]*ResultViolation(nil), h2Conn.monitor.violations...)
+}
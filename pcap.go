@@ -0,0 +1,369 @@
+package h2spec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// pcapng block types and options.
+const (
+	pcapSectionHeader     = 0x0a0d0d0a
+	pcapInterfaceDesc     = 0x00000001
+	pcapEnhancedPacket    = 0x00000006
+	pcapByteOrderMagic    = 0x1a2b3c4d
+	pcapLinkTypeEthernet  = 1
+	pcapOptEnd            = 0
+	pcapOptComment        = 1
+	pcapOptShbUserAppl    = 4
+	pcapOptIfName         = 2
+	pcapMaxSegmentPayload = 32768
+	pcapCleartextPort     = 80
+)
+
+// TCP flags.
+const (
+	tcpFin = 0x01
+	tcpSyn = 0x02
+	tcpPsh = 0x08
+	tcpAck = 0x10
+)
+
+// pcapWriter writes the traffic of the connections opened by h2spec to
+// a pcapng file.  Only the plaintext bytes read and written are known,
+// so the Ethernet, IP and TCP headers around them are synthesized.  The
+// file can be opened in Wireshark without capture privileges, and
+// HTTP/2 over TLS shows up decrypted.
+type pcapWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// createPcap creates the pcapng file at path and writes the section
+// header and the description of the single interface.
+func createPcap(path string) (*pcapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create the capture file (%v)", err)
+	}
+
+	pw := &pcapWriter{f: f, w: bufio.NewWriter(f)}
+
+	var shb []byte
+	shb = appendLe32(shb, pcapByteOrderMagic)
+	shb = appendLe16(shb, 1) // major version
+	shb = appendLe16(shb, 0) // minor version
+	shb = appendLe32(shb, 0xffffffff)
+	shb = appendLe32(shb, 0xffffffff) // section length is not specified
+	shb = appendPcapOption(shb, pcapOptShbUserAppl, []byte("h2spec"))
+	shb = appendPcapOption(shb, pcapOptEnd, nil)
+	pw.writeBlock(pcapSectionHeader, shb)
+
+	var idb []byte
+	idb = appendLe16(idb, pcapLinkTypeEthernet)
+	idb = appendLe16(idb, 0) // reserved
+	idb = appendLe32(idb, 0) // no snapshot length
+	idb = appendPcapOption(idb, pcapOptIfName, []byte("h2spec"))
+	idb = appendPcapOption(idb, pcapOptEnd, nil)
+	pw.writeBlock(pcapInterfaceDesc, idb)
+
+	return pw, nil
+}
+
+// Close flushes the packets written so far and closes the file.
+func (pw *pcapWriter) Close() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	err := pw.w.Flush()
+	if cerr := pw.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (pw *pcapWriter) writeBlock(typ uint32, body []byte) {
+	length := uint32(12 + len(body))
+
+	var b []byte
+	b = appendLe32(b, typ)
+	b = appendLe32(b, length)
+	b = append(b, body...)
+	b = appendLe32(b, length)
+	pw.w.Write(b)
+}
+
+// writePacket writes an Ethernet frame with comment attached to it, if
+// not empty.
+func (pw *pcapWriter) writePacket(frame []byte, comment string) {
+	ts := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+
+	var epb []byte
+	epb = appendLe32(epb, 0) // interface ID
+	epb = appendLe32(epb, uint32(ts>>32))
+	epb = appendLe32(epb, uint32(ts))
+	epb = appendLe32(epb, uint32(len(frame)))
+	epb = appendLe32(epb, uint32(len(frame)))
+	epb = append(epb, frame...)
+	epb = append(epb, make([]byte, pad4(len(frame)))...)
+	if comment != "" {
+		epb = appendPcapOption(epb, pcapOptComment, []byte(comment))
+	}
+	epb = appendPcapOption(epb, pcapOptEnd, nil)
+	pw.writeBlock(pcapEnhancedPacket, epb)
+}
+
+func appendLe16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendLe32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// appendPcapOption appends an option padded to 32 bits.
+func appendPcapOption(b []byte, code uint16, value []byte) []byte {
+	b = appendLe16(b, code)
+	b = appendLe16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// pcapEndpoint is one end of a captured connection.
+type pcapEndpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+func pcapEndpointOf(addr net.Addr) pcapEndpoint {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return pcapEndpoint{tcpAddr.IP, uint16(tcpAddr.Port)}
+	}
+	return pcapEndpoint{net.IPv4(127, 0, 0, 1), 0}
+}
+
+// pcapConn writes the bytes read from and written to the underlying
+// connection to a pcapWriter as TCP segments.  The connection starts
+// with a three-way handshake and ends with FIN segments, so that
+// Wireshark reassembles the stream.
+type pcapConn struct {
+	net.Conn
+	pw        *pcapWriter
+	local     pcapEndpoint
+	remote    pcapEndpoint
+	localSeq  uint32 // the next sequence number sent by local
+	remoteSeq uint32 // the next sequence number sent by remote
+	localFin  bool
+	remoteFin bool
+}
+
+// newPcapConn returns conn wrapped so that its traffic is written to pw.
+// server is true if the local endpoint accepted the connection.  A port
+// other than 0 replaces the port of the server endpoint in the headers.
+// comment is attached to the first segment.
+func newPcapConn(pw *pcapWriter, conn net.Conn, server bool, port uint16, comment string) *pcapConn {
+	c := &pcapConn{
+		Conn:   conn,
+		pw:     pw,
+		local:  pcapEndpointOf(conn.LocalAddr()),
+		remote: pcapEndpointOf(conn.RemoteAddr()),
+	}
+	if port != 0 && server {
+		c.local.port = port
+	} else if port != 0 {
+		c.remote.port = port
+	}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	client := !server
+	c.segment(client, tcpSyn, nil, comment)
+	c.segment(!client, tcpSyn|tcpAck, nil, "")
+	c.segment(client, tcpAck, nil, "")
+
+	return c
+}
+
+// segment writes a TCP segment sent by local if local is true, or by
+// remote otherwise, and advances the sequence number of the sender.
+// The caller must hold pw.mu.
+func (c *pcapConn) segment(local bool, flags byte, payload []byte, comment string) {
+	src, dst := c.remote, c.local
+	seq, ack := &c.remoteSeq, c.localSeq
+	if local {
+		src, dst = c.local, c.remote
+		seq, ack = &c.localSeq, c.remoteSeq
+	}
+	if flags&tcpAck == 0 {
+		ack = 0
+	}
+
+	c.pw.writePacket(tcpPacket(src, dst, *seq, ack, flags, payload), comment)
+
+	*seq += uint32(len(payload))
+	if flags&(tcpSyn|tcpFin) != 0 {
+		*seq++
+	}
+}
+
+// record writes data sent by local if local is true, or by remote
+// otherwise, as segments small enough for an IP packet.
+func (c *pcapConn) record(local bool, data []byte) {
+	c.pw.mu.Lock()
+	defer c.pw.mu.Unlock()
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > pcapMaxSegmentPayload {
+			n = pcapMaxSegmentPayload
+		}
+		c.segment(local, tcpPsh|tcpAck, data[:n], "")
+		data = data[n:]
+	}
+}
+
+// fin writes the FIN segment of local if local is true, or of remote
+// otherwise, once.
+func (c *pcapConn) fin(local bool) {
+	c.pw.mu.Lock()
+	defer c.pw.mu.Unlock()
+
+	if local && !c.localFin {
+		c.localFin = true
+		c.segment(true, tcpFin|tcpAck, nil, "")
+	} else if !local && !c.remoteFin {
+		c.remoteFin = true
+		c.segment(false, tcpFin|tcpAck, nil, "")
+	}
+}
+
+func (c *pcapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(false, p[:n])
+	}
+	if err == io.EOF {
+		c.fin(false)
+	}
+	return n, err
+}
+
+func (c *pcapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.record(true, p[:n])
+	}
+	return n, err
+}
+
+func (c *pcapConn) Close() error {
+	c.fin(true)
+	return c.Conn.Close()
+}
+
+// tcpPacket returns an Ethernet frame carrying a TCP segment from src to
+// dst over IPv4, or IPv6 if either address is not an IPv4 one.
+func tcpPacket(src, dst pcapEndpoint, seq, ack uint32, flags byte, payload []byte) []byte {
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	tcp = append(tcp, payload...)
+
+	frame := make([]byte, 12) // no MAC addresses
+	src4, dst4 := src.ip.To4(), dst.ip.To4()
+
+	var pseudo []byte
+	if src4 != nil && dst4 != nil {
+		frame = append(frame, 0x08, 0x00)
+
+		ip := make([]byte, 20)
+		ip[0] = 0x45 // version and header length
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = 64                                 // TTL
+		ip[9] = 6                                  // TCP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], inetChecksum(0, ip))
+		frame = append(frame, ip...)
+
+		pseudo = append(append(pseudo, src4...), dst4...)
+		pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	} else {
+		frame = append(frame, 0x86, 0xdd)
+
+		ip := make([]byte, 40)
+		ip[0] = 0x60 // version
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6  // TCP
+		ip[7] = 64 // hop limit
+		copy(ip[8:], src.ip.To16())
+		copy(ip[24:], dst.ip.To16())
+		frame = append(frame, ip...)
+
+		pseudo = append(append(pseudo, src.ip.To16()...), dst.ip.To16()...)
+		pseudo = appendUint16(pseudo, 0)
+		pseudo = appendUint16(pseudo, uint16(len(tcp)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+	}
+
+	binary.BigEndian.PutUint16(tcp[16:], inetChecksum(inetSum(0, pseudo), tcp))
+	return append(frame, tcp...)
+}
+
+// inetSum adds b to the one's complement sum.
+func inetSum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// inetChecksum returns the Internet checksum of b, starting from sum.
+func inetChecksum(sum uint32, b []byte) uint16 {
+	sum = inetSum(sum, b)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// capture returns conn wrapped so that its traffic is written to the
+// capture file, if ctx.Pcap is set.  server is true if h2spec accepted
+// the connection.  The first segment is annotated with the test case.
+func (ctx *Context) capture(conn net.Conn, server bool) net.Conn {
+	if ctx.pcap == nil {
+		return conn
+	}
+
+	comment := ""
+	if ctx.testCase != nil {
+		comment = fmt.Sprintf("%s %s", ctx.testCase.ID(), ctx.testCase.Desc)
+	}
+	// the plaintext of a TLS connection on the TLS port would be taken
+	// for TLS records, so the server is put on the cleartext HTTP port.
+	var port uint16
+	if ctx.Tls {
+		port = pcapCleartextPort
+	}
+	return newPcapConn(ctx.pcap, conn, server, port, comment)
+}
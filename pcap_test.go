@@ -0,0 +1,154 @@
+package h2spec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestInetChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		// RFC 1071, 3.
+		{"RFC 1071 example", []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}, 0x220d},
+		{
+			"IPv4 header",
+			[]byte{
+				0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11,
+				0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7,
+			},
+			0xb861,
+		},
+		{"odd length", []byte{0x01, 0x02, 0x03}, ^uint16(0x0402)},
+		{"carries folded twice", []byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x01}, 0xfffe},
+		{"empty", nil, 0xffff},
+	}
+
+	for _, tt := range tests {
+		if got := inetChecksum(0, tt.data); got != tt.want {
+			t.Errorf("%s: got %#04x, want %#04x", tt.name, got, tt.want)
+		}
+	}
+
+	// the sum given is added to the data.
+	data := []byte{0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	if got := inetChecksum(inetSum(0, []byte{0x00, 0x01}), data); got != 0x220d {
+		t.Errorf("with a starting sum: got %#04x, want %#04x", got, 0x220d)
+	}
+}
+
+// checkTcpSegment checks the TCP segment of a packet against the
+// checksum computed over pseudo and the values written.
+func checkTcpSegment(t *testing.T, pseudo, tcp []byte, payload []byte) {
+	t.Helper()
+
+	if got := inetChecksum(inetSum(0, pseudo), tcp); got != 0 {
+		t.Errorf("TCP checksum does not verify (%#04x)", got)
+	}
+	if got := binary.BigEndian.Uint16(tcp[0:]); got != 43210 {
+		t.Errorf("source port: got %d, want 43210", got)
+	}
+	if got := binary.BigEndian.Uint16(tcp[2:]); got != 80 {
+		t.Errorf("destination port: got %d, want 80", got)
+	}
+	if got := binary.BigEndian.Uint32(tcp[4:]); got != 1000 {
+		t.Errorf("sequence number: got %d, want 1000", got)
+	}
+	if got := binary.BigEndian.Uint32(tcp[8:]); got != 2000 {
+		t.Errorf("acknowledgment number: got %d, want 2000", got)
+	}
+	if got := tcp[12] >> 4; got != 5 {
+		t.Errorf("data offset: got %d, want 5", got)
+	}
+	if got := tcp[13]; got != tcpPsh|tcpAck {
+		t.Errorf("flags: got %#02x, want %#02x", got, tcpPsh|tcpAck)
+	}
+	if got := tcp[20:]; !bytes.Equal(got, payload) {
+		t.Errorf("payload: got %q, want %q", got, payload)
+	}
+}
+
+func TestTcpPacketIPv4(t *testing.T) {
+	src := pcapEndpoint{net.IPv4(192, 0, 2, 1), 43210}
+	dst := pcapEndpoint{net.IPv4(192, 0, 2, 2), 80}
+	payload := []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+	pkt := tcpPacket(src, dst, 1000, 2000, tcpPsh|tcpAck, payload)
+
+	if got := binary.BigEndian.Uint16(pkt[12:]); got != 0x0800 {
+		t.Fatalf("EtherType: got %#04x, want 0x0800", got)
+	}
+	ip := pkt[14:34]
+	tcp := pkt[34:]
+
+	if ip[0] != 0x45 {
+		t.Errorf("version and header length: got %#02x, want 0x45", ip[0])
+	}
+	if got := binary.BigEndian.Uint16(ip[2:]); int(got) != len(ip)+len(tcp) {
+		t.Errorf("total length: got %d, want %d", got, len(ip)+len(tcp))
+	}
+	if ip[9] != 6 {
+		t.Errorf("protocol: got %d, want 6", ip[9])
+	}
+	if got := inetChecksum(0, ip); got != 0 {
+		t.Errorf("IP header checksum does not verify (%#04x)", got)
+	}
+	if !net.IP(ip[12:16]).Equal(src.ip) || !net.IP(ip[16:20]).Equal(dst.ip) {
+		t.Errorf("addresses: got %v and %v", net.IP(ip[12:16]), net.IP(ip[16:20]))
+	}
+
+	var pseudo []byte
+	pseudo = append(pseudo, ip[12:20]...)
+	pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	checkTcpSegment(t, pseudo, tcp, payload)
+}
+
+func TestTcpPacketIPv6(t *testing.T) {
+	src := pcapEndpoint{net.ParseIP("2001:db8::1"), 43210}
+	dst := pcapEndpoint{net.ParseIP("2001:db8::2"), 80}
+	payload := []byte("odd")
+
+	pkt := tcpPacket(src, dst, 1000, 2000, tcpPsh|tcpAck, payload)
+
+	if got := binary.BigEndian.Uint16(pkt[12:]); got != 0x86dd {
+		t.Fatalf("EtherType: got %#04x, want 0x86dd", got)
+	}
+	ip := pkt[14:54]
+	tcp := pkt[54:]
+
+	if ip[0]>>4 != 6 {
+		t.Errorf("version: got %d, want 6", ip[0]>>4)
+	}
+	if got := binary.BigEndian.Uint16(ip[4:]); int(got) != len(tcp) {
+		t.Errorf("payload length: got %d, want %d", got, len(tcp))
+	}
+	if ip[6] != 6 {
+		t.Errorf("next header: got %d, want 6", ip[6])
+	}
+	if !net.IP(ip[8:24]).Equal(src.ip) || !net.IP(ip[24:40]).Equal(dst.ip) {
+		t.Errorf("addresses: got %v and %v", net.IP(ip[8:24]), net.IP(ip[24:40]))
+	}
+
+	var pseudo []byte
+	pseudo = append(pseudo, ip[8:40]...)
+	pseudo = append(pseudo, 0, 0, byte(len(tcp)>>8), byte(len(tcp)))
+	pseudo = append(pseudo, 0, 0, 0, 6)
+	checkTcpSegment(t, pseudo, tcp, payload)
+}
+
+func TestTcpPacketMixedFamilies(t *testing.T) {
+	src := pcapEndpoint{net.IPv4(192, 0, 2, 1), 43210}
+	dst := pcapEndpoint{net.ParseIP("2001:db8::2"), 80}
+
+	pkt := tcpPacket(src, dst, 0, 0, tcpSyn, nil)
+	if got := binary.BigEndian.Uint16(pkt[12:]); got != 0x86dd {
+		t.Errorf("EtherType: got %#04x, want 0x86dd", got)
+	}
+	if got := net.IP(pkt[14+8 : 14+24]); !got.Equal(src.ip) {
+		t.Errorf("source address: got %v, want %v", got, src.ip)
+	}
+}
@@ -0,0 +1,343 @@
+package h2spec
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Report summarizes the results of a test run.
+type Report struct {
+	Target     *Target      // the server under test
+	StartedAt  time.Time    // the time at which the run started
+	Strict     bool         // true if strict test cases were run
+	Groups     []*TestGroup // the top level test groups which were run
+	Tests      int          // the number of test cases
+	Passed     int          // the number of passed test cases
+	Skipped    int          // the number of skipped test cases
+	Failed     int          // the number of failed test cases
+	Errors     int          // the number of test cases ended with an error
+	Advisories int          // the number of test cases which raised an advisory
+}
+
+// Target describes the server under test as observed during the run.
+type Target struct {
+	Host     string
+	Port     int
+	Tls      bool
+	Protocol string                     // the protocol negotiated by ALPN
+	Settings map[http2.SettingID]uint32 // SETTINGS sent by the server
+	mu       sync.Mutex
+}
+
+// NewReport returns an empty report for a run against the target
+// server described by ctx.
+func NewReport(ctx *Context) *Report {
+	return &Report{
+		Target: &Target{
+			Host: ctx.Host,
+			Port: ctx.Port,
+			Tls:  ctx.Tls,
+		},
+		StartedAt: time.Now(),
+		Strict:    ctx.Strict,
+	}
+}
+
+// Collect counts the results of the test cases under groups.
+func (r *Report) Collect(groups []*TestGroup) {
+	for _, tg := range groups {
+		if tg == nil {
+			continue
+		}
+		r.Groups = append(r.Groups, tg)
+		r.Tests += tg.CountTestCases()
+		r.Skipped += tg.CountSkipped()
+		r.Failed += tg.CountFailed()
+		r.Errors += tg.CountErrored()
+		r.Advisories += tg.CountAdvisory()
+	}
+
+	r.Passed = r.Tests - r.Skipped - r.Failed - r.Errors - r.Advisories
+}
+
+// Pass returns true if no test case failed or ended with an error.
+// Advisories do not make a run fail.
+func (r *Report) Pass() bool {
+	return r.Failed == 0 && r.Errors == 0
+}
+
+// recordConnState keeps the protocol negotiated on the first TLS
+// connection to the target.
+func (t *Target) recordConnState(cs tls.ConnectionState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Protocol == "" {
+		t.Protocol = cs.NegotiatedProtocol
+	}
+}
+
+// recordSettings keeps the SETTINGS sent by the target on the first
+// successful negotiation.
+func (t *Target) recordSettings(settings map[http2.SettingID]uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Settings != nil {
+		return
+	}
+
+	t.Settings = map[http2.SettingID]uint32{}
+	for id, val := range settings {
+		t.Settings[id] = val
+	}
+}
+
+type jsonReport struct {
+	Target     *jsonTarget  `json:"target"`
+	StartedAt  time.Time    `json:"started_at"`
+	Tests      int          `json:"tests"`
+	Passed     int          `json:"passed"`
+	Skipped    int          `json:"skipped"`
+	Failed     int          `json:"failed"`
+	Errors     int          `json:"errors"`
+	Advisories int          `json:"advisories"`
+	Groups     []*jsonGroup `json:"groups"`
+
+	// Connections maps the source port of every connection to the
+	// test case which opened it, to correlate a capture with the run.
+	Connections []*jsonConnection `json:"connections,omitempty"`
+}
+
+type jsonConnection struct {
+	SourcePort  int    `json:"source_port"`
+	TestCase    string `json:"test_case"`
+	Description string `json:"description"`
+}
+
+type jsonTarget struct {
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	Tls      bool              `json:"tls"`
+	Protocol string            `json:"protocol,omitempty"`
+	Settings map[string]uint32 `json:"settings,omitempty"`
+}
+
+type jsonGroup struct {
+	Section   string          `json:"section"`
+	Name      string          `json:"name"`
+	TestCases []*jsonTestCase `json:"test_cases,omitempty"`
+	Groups    []*jsonGroup    `json:"groups,omitempty"`
+}
+
+type jsonTestCase struct {
+	Description string        `json:"description"`
+	Spec        string        `json:"spec"`
+	Status      string        `json:"status"`
+	Expected    []*jsonResult `json:"expected,omitempty"`
+	Actual      *jsonResult   `json:"actual,omitempty"`
+	Duration    float64       `json:"duration"` // in seconds
+	Trace       []string      `json:"trace,omitempty"`
+}
+
+type jsonResult struct {
+	Type      string   `json:"type"`
+	Message   string   `json:"message"`
+	FrameType string   `json:"frame_type,omitempty"`
+	Length    *int64   `json:"length,omitempty"`
+	Flags     *int     `json:"flags,omitempty"`
+	ErrorCode string   `json:"error_code,omitempty"`
+	Frames    *int     `json:"frames,omitempty"`
+	Octets    *int     `json:"octets,omitempty"`
+	Window    *int64   `json:"window,omitempty"`
+	Elapsed   *float64 `json:"elapsed,omitempty"` // in seconds
+}
+
+func newJsonTarget(t *Target) *jsonTarget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	jt := &jsonTarget{
+		Host:     t.Host,
+		Port:     t.Port,
+		Tls:      t.Tls,
+		Protocol: t.Protocol,
+	}
+
+	if t.Settings != nil {
+		jt.Settings = map[string]uint32{}
+		for id, val := range t.Settings {
+			jt.Settings[id.String()] = val
+		}
+	}
+
+	return jt
+}
+
+func newJsonGroup(tg *TestGroup) *jsonGroup {
+	jg := &jsonGroup{
+		Section: tg.Section,
+		Name:    tg.Name,
+	}
+
+	for _, tc := range tg.testCases {
+		jtc := &jsonTestCase{
+			Description: tc.Desc,
+			Spec:        tc.Spec,
+			Status:      tc.Result().String(),
+			Actual:      newJsonResult(tc.actual),
+			Duration:    tc.testTime.Seconds(),
+		}
+		for _, exp := range tc.expected {
+			jtc.Expected = append(jtc.Expected, newJsonResult(exp))
+		}
+		if tc.trace != nil {
+			jtc.Trace = tc.trace.Lines()
+		}
+		jg.TestCases = append(jg.TestCases, jtc)
+	}
+
+	for _, child := range tg.testGroups {
+		jg.Groups = append(jg.Groups, newJsonGroup(child))
+	}
+
+	return jg
+}
+
+// appendJsonConnections appends the connections opened by the test
+// cases of tg and its children to conns.
+func appendJsonConnections(conns []*jsonConnection, tg *TestGroup) []*jsonConnection {
+	for _, tc := range tg.testCases {
+		for _, port := range tc.ports {
+			conns = append(conns, &jsonConnection{
+				SourcePort:  port,
+				TestCase:    tc.ID(),
+				Description: tc.Desc,
+			})
+		}
+	}
+
+	for _, child := range tg.testGroups {
+		conns = appendJsonConnections(conns, child)
+	}
+
+	return conns
+}
+
+func newJsonResult(r Result) *jsonResult {
+	if r == nil {
+		return nil
+	}
+
+	jr := &jsonResult{Message: r.String()}
+
+	if rf, ok := r.(*ResultFlood); ok {
+		// describe the reaction of the target, with the amount of
+		// frames it took.
+		if rf.Reaction != nil {
+			jr = newJsonResult(rf.Reaction)
+			jr.Message = rf.String()
+		} else {
+			jr.Type = "no_reaction"
+		}
+		elapsed := rf.Elapsed.Seconds()
+		jr.Frames = &rf.Frames
+		jr.Octets = &rf.Octets
+		jr.Elapsed = &elapsed
+		return jr
+	}
+
+	switch r := r.(type) {
+	case *ResultFrame:
+		jr.Type = "frame"
+		jr.FrameType = r.Type.String()
+		if r.Length != LengthDefault {
+			length := int64(r.Length)
+			jr.Length = &length
+		}
+		if r.Flags != FlagDefault {
+			flags := int(r.Flags)
+			jr.Flags = &flags
+		}
+		if r.ErrCode != ErrCodeDefault {
+			jr.ErrorCode = r.ErrCode.String()
+		}
+	case *ResultConnectionClose:
+		jr.Type = "connection_close"
+	case *ResultStreamClose:
+		jr.Type = "stream_close"
+	case *ResultTestTimeout:
+		jr.Type = "timeout"
+	case *ResultMalformedHeader:
+		jr.Type = "malformed_header"
+	case *ResultIllegalFrame:
+		jr.Type = "illegal_frame"
+		jr.FrameType = r.Type.String()
+	case *ResultViolation:
+		jr.Type = "protocol_violation"
+		if r.Warning {
+			jr.Type = "warning"
+		}
+		jr.FrameType = r.Type.String()
+	case *ResultFlowControl:
+		jr.Type = "flow_control"
+		if r.Octets >= 0 {
+			octets := int(r.Octets)
+			jr.Octets = &octets
+		}
+		jr.Window = &r.Window
+	case *ResultHandshakeFailure:
+		jr.Type = "handshake_failure"
+	case *ResultNegotiatedProtocol:
+		jr.Type = "negotiated_protocol"
+	case *ResultTlsCompression:
+		jr.Type = "tls_compression"
+	case *ResultTlsRenegotiation:
+		jr.Type = "tls_renegotiation"
+	case *ResultBackPressure:
+		jr.Type = "back_pressure"
+	case *ResultInconclusive:
+		jr.Type = "inconclusive"
+	case *ResultSkipped:
+		jr.Type = "skipped"
+	case *ResultError:
+		jr.Type = "error"
+	default:
+		jr.Type = "other"
+	}
+
+	return jr
+}
+
+// WriteJSON writes the report with the full tree of test results to
+// the file at path.
+func (r *Report) WriteJSON(path string) error {
+	jr := &jsonReport{
+		Target:     newJsonTarget(r.Target),
+		StartedAt:  r.StartedAt,
+		Tests:      r.Tests,
+		Passed:     r.Passed,
+		Skipped:    r.Skipped,
+		Failed:     r.Failed,
+		Errors:     r.Errors,
+		Advisories: r.Advisories,
+		Groups:     []*jsonGroup{},
+	}
+
+	for _, tg := range r.Groups {
+		jr.Groups = append(jr.Groups, newJsonGroup(tg))
+		jr.Connections = appendJsonConnections(jr.Connections, tg)
+	}
+
+	data, err := json.MarshalIndent(jr, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,161 @@
+package h2spec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestWriteJSON(t *testing.T) {
+	report := junitTestReport()
+	report.Target.Protocol = "h2"
+	report.Target.Settings = map[http2.SettingID]uint32{http2.SettingMaxConcurrentStreams: 100}
+	report.Groups[0].testCases[0].ports = []int{50000, 50001}
+	report.Tests, report.Passed, report.Failed = 5, 1, 1
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	err := report.WriteJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jr jsonReport
+	err = json.Unmarshal(data, &jr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := jr.Target
+	if target.Host != "127.0.0.1" || target.Port != 8443 || !target.Tls || target.Protocol != "h2" {
+		t.Errorf("target: got %+v", target)
+	}
+	if len(target.Settings) != 1 || target.Settings["MAX_CONCURRENT_STREAMS"] != 100 {
+		t.Errorf("settings: got %v", target.Settings)
+	}
+	if !jr.StartedAt.Equal(report.StartedAt) {
+		t.Errorf("started_at: got %v", jr.StartedAt)
+	}
+	if jr.Tests != 5 || jr.Passed != 1 || jr.Failed != 1 {
+		t.Errorf("counts: got %d tests, %d passed, %d failed, want 5, 1, 1", jr.Tests, jr.Passed, jr.Failed)
+	}
+
+	if len(jr.Groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(jr.Groups))
+	}
+	group := jr.Groups[0]
+	if group.Section != "6.5" || group.Name != "SETTINGS" || len(group.TestCases) != 4 {
+		t.Errorf("group: got %q %q with %d test cases", group.Section, group.Name, len(group.TestCases))
+	}
+	if len(group.Groups) != 1 || group.Groups[0].Section != "6.5.3" || len(group.Groups[0].TestCases) != 1 {
+		t.Fatalf("nested group is missing")
+	}
+
+	statuses := []string{"passed", "failed", "error", "advisory"}
+	for i, jtc := range group.TestCases {
+		if jtc.Status != statuses[i] {
+			t.Errorf("%s: got status %q, want %q", jtc.Description, jtc.Status, statuses[i])
+		}
+	}
+	if skipped := group.Groups[0].TestCases[0]; skipped.Status != "skipped" || skipped.Actual.Type != "skipped" {
+		t.Errorf("skipped test case: got status %q and result %+v", skipped.Status, skipped.Actual)
+	}
+
+	passed := group.TestCases[0]
+	if passed.Actual != nil || passed.Expected != nil || passed.Duration != 0.25 {
+		t.Errorf("passed test case: got %+v", passed)
+	}
+
+	failed := group.TestCases[1]
+	if failed.Spec != "The endpoint MUST respond." || failed.Duration != 1 {
+		t.Errorf("failed test case: got spec %q and duration %v", failed.Spec, failed.Duration)
+	}
+	if len(failed.Expected) != 1 {
+		t.Fatalf("got %d expected results, want 1", len(failed.Expected))
+	}
+	exp := failed.Expected[0]
+	if exp.Type != "frame" || exp.FrameType != "GOAWAY" || exp.ErrorCode != "PROTOCOL_ERROR" {
+		t.Errorf("expected result: got %+v", exp)
+	}
+	if exp.Length != nil || exp.Flags != nil {
+		t.Errorf("default length and flags of the expected frame are not omitted")
+	}
+	if failed.Actual == nil || failed.Actual.Type != "timeout" || failed.Actual.Message != (&ResultTestTimeout{}).String() {
+		t.Errorf("actual result: got %+v", failed.Actual)
+	}
+
+	if len(jr.Connections) != 2 {
+		t.Fatalf("got %d connections, want 2", len(jr.Connections))
+	}
+	for i, port := range []int{50000, 50001} {
+		conn := jr.Connections[i]
+		if conn.SourcePort != port || conn.TestCase != "6.5/1" || conn.Description != "passes" {
+			t.Errorf("connection: got %+v", conn)
+		}
+	}
+}
+
+func TestNewJsonResult(t *testing.T) {
+	if newJsonResult(nil) != nil {
+		t.Errorf("nil result is not omitted")
+	}
+
+	jr := newJsonResult(&ResultFrame{8, http2.FrameSettings, http2.FlagSettingsAck, ErrCodeDefault})
+	if jr.Type != "frame" || jr.FrameType != "SETTINGS" || jr.ErrorCode != "" {
+		t.Errorf("frame: got %+v", jr)
+	}
+	if jr.Length == nil || *jr.Length != 8 || jr.Flags == nil || *jr.Flags != 1 {
+		t.Errorf("length and flags of the frame: got %v and %v", jr.Length, jr.Flags)
+	}
+
+	jr = newJsonResult(&ResultFlowControl{Octets: -1, Window: 65535})
+	if jr.Type != "flow_control" || jr.Octets != nil || jr.Window == nil || *jr.Window != 65535 {
+		t.Errorf("flow control: got %+v", jr)
+	}
+
+	// a flood is described by the reaction of the target.
+	rf := &ResultFlood{
+		Reaction: &ResultFrame{LengthDefault, http2.FrameGoAway, FlagDefault, http2.ErrCodeEnhanceYourCalm},
+		Frames:   1000,
+		Octets:   9000,
+		Elapsed:  1500 * time.Millisecond,
+	}
+	jr = newJsonResult(rf)
+	if jr.Type != "frame" || jr.FrameType != "GOAWAY" || jr.ErrorCode != "ENHANCE_YOUR_CALM" || jr.Message != rf.String() {
+		t.Errorf("flood: got %+v", jr)
+	}
+	if jr.Frames == nil || *jr.Frames != 1000 || jr.Octets == nil || *jr.Octets != 9000 || jr.Elapsed == nil || *jr.Elapsed != 1.5 {
+		t.Errorf("amount of the flood: got %v frames, %v octets, %v seconds", jr.Frames, jr.Octets, jr.Elapsed)
+	}
+
+	jr = newJsonResult(&ResultFlood{Frames: 10})
+	if jr.Type != "no_reaction" || jr.Frames == nil || *jr.Frames != 10 {
+		t.Errorf("flood without reaction: got %+v", jr)
+	}
+
+	tests := []struct {
+		r    Result
+		want string
+	}{
+		{&ResultConnectionClose{}, "connection_close"},
+		{&ResultTestTimeout{}, "timeout"},
+		{&ResultViolation{Type: http2.FrameData}, "protocol_violation"},
+		{&ResultViolation{Type: http2.FrameData, Warning: true}, "warning"},
+		{&ResultTlsRenegotiation{}, "tls_renegotiation"},
+		{&ResultSkipped{"not supported"}, "skipped"},
+	}
+
+	for _, tt := range tests {
+		if got := newJsonResult(tt.r).Type; got != tt.want {
+			t.Errorf("%T: got type %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
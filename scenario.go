@@ -0,0 +1,442 @@
+package h2spec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"gopkg.in/yaml.v2"
+)
+
+// A scenario file describes a test group whose test cases are made of
+// steps, sending frames and expecting the reaction of the target,
+// instead of Go code.  Scenario files are written in YAML (.yaml, .yml)
+// or JSON (.json):
+//
+//	section: vendor/1
+//	name: Regressions of vendor bugs
+//	tests:
+//	  - description: Sends a PING frame with invalid stream ID
+//	    spec: The endpoint MUST respond with a connection error.
+//	    steps:
+//	      - send: {type: PING, stream_id: 3, data: "h2spec!!"}
+//	      - expect:
+//	          - goaway: PROTOCOL_ERROR
+//	          - close: true
+//
+// The alternatives listed by an expect step are met by the first
+// matching frame, the connection close or the timeout.  Other frames
+// are skipped, except for a GOAWAY frame, which fails the step.
+type scenario struct {
+	Section string         `yaml:"section" json:"section"`
+	Name    string         `yaml:"name" json:"name"`
+	Tests   []scenarioTest `yaml:"tests" json:"tests"`
+}
+
+type scenarioTest struct {
+	Description string         `yaml:"description" json:"description"`
+	Spec        string         `yaml:"spec" json:"spec"`
+	Strict      bool           `yaml:"strict" json:"strict"` // run only in strict mode
+	Steps       []scenarioStep `yaml:"steps" json:"steps"`
+}
+
+// scenarioStep has one of its fields set.
+type scenarioStep struct {
+	Send    *scenarioFrame   `yaml:"send" json:"send"`
+	SendRaw string           `yaml:"send_raw" json:"send_raw"` // hex encoded octets
+	Expect  []scenarioExpect `yaml:"expect" json:"expect"`
+}
+
+type scenarioFrame struct {
+	Type          string            `yaml:"type" json:"type"`
+	StreamID      uint32            `yaml:"stream_id" json:"stream_id"`
+	Flags         []string          `yaml:"flags" json:"flags"`
+	CommonHeaders bool              `yaml:"common_headers" json:"common_headers"` // prepend the request header fields of h2spec
+	Headers       []scenarioHeader  `yaml:"headers" json:"headers"`
+	Data          string            `yaml:"data" json:"data"` // DATA payload, PING data or GOAWAY debug data
+	ErrorCode     string            `yaml:"error_code" json:"error_code"`
+	LastStreamID  uint32            `yaml:"last_stream_id" json:"last_stream_id"`
+	Increment     uint32            `yaml:"increment" json:"increment"`
+	PromiseID     uint32            `yaml:"promise_id" json:"promise_id"`
+	Settings      []scenarioSetting `yaml:"settings" json:"settings"`
+	Exclusive     bool              `yaml:"exclusive" json:"exclusive"`
+	StreamDep     uint32            `yaml:"stream_dependency" json:"stream_dependency"`
+	Weight        uint8             `yaml:"weight" json:"weight"`
+	Payload       *string           `yaml:"payload" json:"payload"` // hex encoded payload replacing the fields above
+}
+
+type scenarioHeader struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
+}
+
+type scenarioSetting struct {
+	ID    string `yaml:"id" json:"id"` // like MAX_FRAME_SIZE, or a number
+	Value uint32 `yaml:"value" json:"value"`
+}
+
+// scenarioExpect has one of its fields set.
+type scenarioExpect struct {
+	Frame     string   `yaml:"frame" json:"frame"` // a frame of the type
+	Flags     []string `yaml:"flags" json:"flags"` // flags which must be set on frame
+	GoAway    string   `yaml:"goaway" json:"goaway"`
+	RstStream string   `yaml:"rst_stream" json:"rst_stream"`
+	Close     bool     `yaml:"close" json:"close"`
+	Timeout   bool     `yaml:"timeout" json:"timeout"`
+}
+
+var scenarioFlags = map[string]http2.Flags{
+	"END_STREAM":  http2.FlagDataEndStream,
+	"ACK":         http2.FlagSettingsAck,
+	"END_HEADERS": http2.FlagHeadersEndHeaders,
+	"PADDED":      http2.FlagDataPadded,
+	"PRIORITY":    http2.FlagHeadersPriority,
+}
+
+func parseFrameType(s string) (http2.FrameType, error) {
+	for t := http2.FrameData; t <= http2.FrameContinuation; t++ {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown frame type %q", s)
+}
+
+func parseErrCode(s string) (http2.ErrCode, error) {
+	for c := http2.ErrCodeNo; c <= http2.ErrCodeHTTP11Required; c++ {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown error code %q", s)
+}
+
+func parseFlags(names []string) (http2.Flags, error) {
+	var flags http2.Flags
+	for _, name := range names {
+		flag, ok := scenarioFlags[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown flag %q", name)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+func parseSettingID(s string) (http2.SettingID, error) {
+	for id := http2.SettingHeaderTableSize; id <= http2.SettingMaxHeaderListSize; id++ {
+		if id.String() == s {
+			return id, nil
+		}
+	}
+
+	id, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown setting %q", s)
+	}
+	return http2.SettingID(id), nil
+}
+
+func parseHex(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	return hex.DecodeString(s)
+}
+
+// LoadScenarios reads the scenario files in dir and returns a test group
+// for each of them, in the order of their file names.
+func LoadScenarios(ctx *Context, dir string) ([]*TestGroup, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read scenario directory (%v)", err)
+	}
+
+	var names []string
+	for _, fi := range files {
+		switch filepath.Ext(fi.Name()) {
+		case ".yaml", ".yml", ".json":
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var groups []*TestGroup
+	for _, name := range names {
+		sc, err := loadScenario(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scenario file %s (%v)", name, err)
+		}
+		if sc.Section == "" {
+			sc.Section = "scenario/" + strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		groups = append(groups, sc.testGroup(ctx))
+	}
+
+	return groups, nil
+}
+
+func loadScenario(path string) (*scenario, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := new(scenario)
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(sc)
+	} else {
+		err = yaml.UnmarshalStrict(buf, sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// catch mistakes before running anything.  A test without an
+	// expect step would pass whatever the target does.
+	for i, test := range sc.Tests {
+		expects := false
+		for j, step := range test.Steps {
+			if err := step.validate(); err != nil {
+				return nil, fmt.Errorf("test %d, step %d: %v", i+1, j+1, err)
+			}
+			expects = expects || step.Expect != nil
+		}
+		if !expects {
+			return nil, fmt.Errorf("test %d: a test must have an expect step", i+1)
+		}
+	}
+
+	return sc, nil
+}
+
+func (step *scenarioStep) validate() error {
+	n := 0
+	if step.Send != nil {
+		n++
+		if _, err := step.Send.payload(nil, nil); err != nil {
+			return err
+		}
+	}
+	if step.SendRaw != "" {
+		n++
+		if _, err := parseHex(step.SendRaw); err != nil {
+			return err
+		}
+	}
+	if step.Expect != nil {
+		n++
+		if len(step.Expect) == 0 {
+			return fmt.Errorf("an expect step must list at least one reaction")
+		}
+		for _, exp := range step.Expect {
+			if _, err := exp.expectation(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if n != 1 {
+		return fmt.Errorf("a step must have exactly one of send, send_raw or expect")
+	}
+	return nil
+}
+
+func (sc *scenario) testGroup(ctx *Context) *TestGroup {
+	tg := NewTestGroup(sc.Section, sc.Name)
+
+	for _, test := range sc.Tests {
+		if test.Strict && !ctx.Strict {
+			continue
+		}
+
+		test := test
+		tg.AddTestCase(NewTestCase(
+			test.Description,
+			test.Spec,
+			func(ctx *Context) (pass bool, expected []Result, actual Result) {
+				return test.run(ctx)
+			},
+		))
+	}
+
+	return tg
+}
+
+// run performs the steps of the test.  The test passes if every expect
+// step is met.
+func (test *scenarioTest) run(ctx *Context) (pass bool, expected []Result, actual Result) {
+	http2Conn, err := CreateHttp2Conn(ctx, true)
+	if err != nil {
+		return false, expected, &ResultError{err}
+	}
+	defer http2Conn.conn.Close()
+
+	for _, step := range test.Steps {
+		switch {
+		case step.Send != nil:
+			typ, _ := parseFrameType(step.Send.Type)
+			flags, _ := parseFlags(step.Send.Flags)
+			payload, err := step.Send.payload(ctx, http2Conn)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+			err = http2Conn.fr.WriteRawFrame(typ, flags, step.Send.StreamID, payload)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+		case step.SendRaw != "":
+			data, _ := parseHex(step.SendRaw)
+			_, err := http2Conn.conn.Write(data)
+			if err != nil {
+				return false, expected, &ResultError{err}
+			}
+		default:
+			pass, expected, actual = expectScenario(ctx, http2Conn, step.Expect)
+			if !pass {
+				return pass, expected, actual
+			}
+		}
+	}
+
+	return true, expected, actual
+}
+
+// payload returns the payload of the frame.  http2Conn encodes the
+// header fields; payload only checks the fields if it is nil.
+func (sf *scenarioFrame) payload(ctx *Context, http2Conn *Http2Conn) ([]byte, error) {
+	typ, err := parseFrameType(sf.Type)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := parseFlags(sf.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if sf.Payload != nil {
+		return parseHex(*sf.Payload)
+	}
+
+	var buf []byte
+	u32 := func(v uint32) {
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-4:], v)
+	}
+	priority := func() {
+		dep := sf.StreamDep
+		if sf.Exclusive {
+			dep |= 1 << 31
+		}
+		u32(dep)
+		buf = append(buf, sf.Weight)
+	}
+	errCode := func() error {
+		code, err := parseErrCode(sf.ErrorCode)
+		u32(uint32(code))
+		return err
+	}
+	headers := func() {
+		if http2Conn == nil {
+			return
+		}
+		var hdrs []hpack.HeaderField
+		if sf.CommonHeaders {
+			hdrs = commonHeaderFields(ctx)
+		}
+		for _, h := range sf.Headers {
+			hdrs = append(hdrs, pair(h.Name, h.Value))
+		}
+		buf = append(buf, http2Conn.EncodeHeader(hdrs)...)
+	}
+
+	switch typ {
+	case http2.FrameData:
+		buf = append(buf, sf.Data...)
+	case http2.FrameHeaders:
+		if flags.Has(http2.FlagHeadersPriority) {
+			priority()
+		}
+		headers()
+	case http2.FramePriority:
+		priority()
+	case http2.FrameRSTStream:
+		err = errCode()
+	case http2.FrameSettings:
+		for _, s := range sf.Settings {
+			id, err := parseSettingID(s.ID)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, byte(id>>8), byte(id))
+			u32(s.Value)
+		}
+	case http2.FramePushPromise:
+		u32(sf.PromiseID)
+		headers()
+	case http2.FramePing:
+		data := [8]byte{}
+		copy(data[:], sf.Data)
+		buf = append(buf, data[:]...)
+	case http2.FrameGoAway:
+		u32(sf.LastStreamID)
+		err = errCode()
+		buf = append(buf, sf.Data...)
+	case http2.FrameWindowUpdate:
+		u32(sf.Increment)
+	case http2.FrameContinuation:
+		headers()
+	}
+
+	return buf, err
+}
+
+// expectation returns the Expectation described by exp.
+func (exp *scenarioExpect) expectation() (Expectation, error) {
+	switch {
+	case exp.Frame != "":
+		typ, err := parseFrameType(exp.Frame)
+		if err != nil {
+			return Expectation{}, err
+		}
+		flags := FlagDefault
+		if exp.Flags != nil {
+			if flags, err = parseFlags(exp.Flags); err != nil {
+				return Expectation{}, err
+			}
+		}
+		return ExpectFrame(typ, flags), nil
+	case exp.GoAway != "":
+		code, err := parseErrCode(exp.GoAway)
+		return ExpectGoAway(code), err
+	case exp.RstStream != "":
+		code, err := parseErrCode(exp.RstStream)
+		return ExpectRstStream(code), err
+	case exp.Close:
+		return ExpectConnectionClose(), nil
+	case exp.Timeout:
+		return ExpectTimeout(), nil
+	}
+
+	return Expectation{}, fmt.Errorf("an expectation must have one of frame, goaway, rst_stream, close or timeout")
+}
+
+// expectScenario reads frames until one of alternatives is met.
+func expectScenario(ctx *Context, http2Conn *Http2Conn, alternatives []scenarioExpect) (pass bool, expected []Result, actual Result) {
+	var exps []Expectation
+	for _, alt := range alternatives {
+		exp, _ := alt.expectation()
+		exps = append(exps, exp)
+	}
+
+	return http2Conn.Expect(ctx, exps...)
+}
@@ -0,0 +1,298 @@
+package h2spec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// writeScenario writes a scenario file named name with content in a
+// temporary directory, and returns its path.
+func writeScenario(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadScenario(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "ping.yaml",
+			content: `
+section: vendor/1
+name: Regressions of vendor bugs
+tests:
+  - description: Sends a PING frame with invalid stream ID
+    spec: The endpoint MUST respond with a connection error.
+    strict: true
+    steps:
+      - send: {type: PING, stream_id: 3, data: "h2spec!!"}
+      - send_raw: "00 00 00"
+      - expect:
+          - goaway: PROTOCOL_ERROR
+          - close: true
+`,
+		},
+		{
+			name: "ping.json",
+			content: `{
+  "section": "vendor/1",
+  "name": "Regressions of vendor bugs",
+  "tests": [{
+    "description": "Sends a PING frame with invalid stream ID",
+    "spec": "The endpoint MUST respond with a connection error.",
+    "strict": true,
+    "steps": [
+      {"send": {"type": "PING", "stream_id": 3, "data": "h2spec!!"}},
+      {"send_raw": "00 00 00"},
+      {"expect": [{"goaway": "PROTOCOL_ERROR"}, {"close": true}]}
+    ]
+  }]
+}`,
+		},
+	}
+
+	want := &scenario{
+		Section: "vendor/1",
+		Name:    "Regressions of vendor bugs",
+		Tests: []scenarioTest{{
+			Description: "Sends a PING frame with invalid stream ID",
+			Spec:        "The endpoint MUST respond with a connection error.",
+			Strict:      true,
+			Steps: []scenarioStep{
+				{Send: &scenarioFrame{Type: "PING", StreamID: 3, Data: "h2spec!!"}},
+				{SendRaw: "00 00 00"},
+				{Expect: []scenarioExpect{{GoAway: "PROTOCOL_ERROR"}, {Close: true}}},
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		sc, err := loadScenario(writeScenario(t, tt.name, tt.content))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sc, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, sc, want)
+		}
+	}
+}
+
+func TestLoadScenarioInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name: "unknown.yaml",
+			content: `
+tests:
+  - description: misspelled
+    stesp:
+      - expect: [{close: true}]
+`,
+			err: "stesp",
+		},
+		{
+			name:    "unknown.json",
+			content: `{"tests": [{"description": "misspelled", "steps": [{"expect": [{"closed": true}]}]}]}`,
+			err:     "closed",
+		},
+		{
+			name: "noexpect.yaml",
+			content: `
+tests:
+  - description: sends only
+    steps:
+      - send: {type: PING, data: "h2spec!!"}
+`,
+			err: "test 1: a test must have an expect step",
+		},
+		{
+			name:    "noexpect.json",
+			content: `{"tests": [{"description": "no steps"}]}`,
+			err:     "test 1: a test must have an expect step",
+		},
+		{
+			name: "emptyexpect.yaml",
+			content: `
+tests:
+  - description: expects nothing
+    steps:
+      - expect: []
+`,
+			err: "test 1, step 1: an expect step must list at least one reaction",
+		},
+		{
+			name: "twofold.yaml",
+			content: `
+tests:
+  - description: sends and expects at once
+    steps:
+      - send: {type: PING, data: "h2spec!!"}
+        expect: [{close: true}]
+`,
+			err: "test 1, step 1: a step must have exactly one of send, send_raw or expect",
+		},
+		{
+			name: "frametype.yaml",
+			content: `
+tests:
+  - description: sends an unknown frame type
+    steps:
+      - send: {type: PONG}
+      - expect: [{close: true}]
+`,
+			err: `test 1, step 1: unknown frame type "PONG"`,
+		},
+		{
+			name: "hex.yaml",
+			content: `
+tests:
+  - description: sends invalid octets
+    steps:
+      - send_raw: "0g"
+      - expect: [{close: true}]
+`,
+			err: "test 1, step 1:",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := loadScenario(writeScenario(t, tt.name, tt.content))
+		if err == nil {
+			t.Errorf("%s: loaded, want an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %q, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLoadScenarios(t *testing.T) {
+	dir := t.TempDir()
+	content := map[string]string{
+		"b.yaml":   "name: B\ntests: [{description: b, steps: [{expect: [{close: true}]}]}]\n",
+		"a.json":   `{"section": "vendor/1", "name": "A", "tests": [{"description": "a", "steps": [{"expect": [{"timeout": true}]}]}]}`,
+		"notes.md": "not a scenario",
+	}
+	for name, c := range content {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(c), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := LoadScenarios(&Context{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if groups[0].Section != "vendor/1" || groups[0].Name != "A" {
+		t.Errorf("first group: got %q %q", groups[0].Section, groups[0].Name)
+	}
+	if groups[1].Section != "scenario/b" || groups[1].Name != "B" {
+		t.Errorf("second group: got %q %q", groups[1].Section, groups[1].Name)
+	}
+}
+
+func TestScenarioPayload(t *testing.T) {
+	hexPayload := "00 01\n02 03"
+
+	tests := []struct {
+		frame scenarioFrame
+		want  string
+	}{
+		{scenarioFrame{Type: "DATA", Data: "test"}, "74657374"},
+		{scenarioFrame{Type: "PRIORITY", Exclusive: true, StreamDep: 3, Weight: 15}, "800000030f"},
+		{scenarioFrame{Type: "RST_STREAM", ErrorCode: "CANCEL"}, "00000008"},
+		{
+			scenarioFrame{Type: "SETTINGS", Settings: []scenarioSetting{{"MAX_FRAME_SIZE", 16384}, {"0x10", 1}}},
+			"000500004000" + "001000000001",
+		},
+		{scenarioFrame{Type: "SETTINGS", Flags: []string{"ACK"}}, ""},
+		{scenarioFrame{Type: "PING", Data: "h2"}, "6832000000000000"},
+		{scenarioFrame{Type: "GOAWAY", LastStreamID: 5, ErrorCode: "PROTOCOL_ERROR", Data: "bye"}, "00000005" + "00000001" + "627965"},
+		{scenarioFrame{Type: "WINDOW_UPDATE", Increment: 1024}, "00000400"},
+		{scenarioFrame{Type: "PUSH_PROMISE", PromiseID: 2}, "00000002"},
+		{scenarioFrame{Type: "DATA", Data: "ignored", Payload: &hexPayload}, "00010203"},
+	}
+
+	for _, tt := range tests {
+		payload, err := tt.frame.payload(nil, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.frame.Type, err)
+			continue
+		}
+		if !bytes.Equal(payload, mustHex(t, tt.want)) {
+			t.Errorf("%s: got %x, want %s", tt.frame.Type, payload, tt.want)
+		}
+	}
+
+	invalid := []scenarioFrame{
+		{Type: "PONG"},
+		{Type: "DATA", Flags: []string{"END_FRAME"}},
+		{Type: "RST_STREAM", ErrorCode: "OOPS"},
+		{Type: "SETTINGS", Settings: []scenarioSetting{{"MAX_WINDOW", 1}}},
+	}
+
+	for _, sf := range invalid {
+		if _, err := sf.payload(nil, nil); err == nil {
+			t.Errorf("%+v: encoded, want an error", sf)
+		}
+	}
+}
+
+func TestScenarioPayloadHeaders(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	http2Conn := newHttp2Conn(conn, map[http2.SettingID]uint32{})
+
+	ctx := &Context{Host: "127.0.0.1", Port: 8080}
+	sf := &scenarioFrame{
+		Type:          "HEADERS",
+		Flags:         []string{"END_HEADERS", "PRIORITY"},
+		CommonHeaders: true,
+		Headers:       []scenarioHeader{{"x-dummy", "1"}},
+		StreamDep:     1,
+		Weight:        255,
+	}
+	payload, err := sf.payload(ctx, http2Conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(payload[:5], mustHex(t, "00000001ff")) {
+		t.Errorf("priority: got %x", payload[:5])
+	}
+
+	hl, err := hpack.NewDecoder(4096, nil).DecodeFull(payload[5:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(commonHeaderFields(ctx), pair("x-dummy", "1"))
+	if !reflect.DeepEqual(hl, want) {
+		t.Errorf("header fields: got %v, want %v", hl, want)
+	}
+}
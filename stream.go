@@ -0,0 +1,328 @@
+package h2spec
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// StreamState is the state of a stream defined in RFC 7540, Section
+// 5.1.
+type StreamState int
+
+const (
+	StateIdle StreamState = iota
+	StateReservedLocal
+	StateReservedRemote
+	StateOpen
+	StateHalfClosedLocal
+	StateHalfClosedRemote
+	StateClosed
+)
+
+var streamStateNames = map[StreamState]string{
+	StateIdle:             "idle",
+	StateReservedLocal:    "reserved (local)",
+	StateReservedRemote:   "reserved (remote)",
+	StateOpen:             "open",
+	StateHalfClosedLocal:  "half-closed (local)",
+	StateHalfClosedRemote: "half-closed (remote)",
+	StateClosed:           "closed",
+}
+
+func (s StreamState) String() string {
+	if name, ok := streamStateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// streamTracker follows the state of every stream of a connection as
+// frames are sent and received.  "local" is h2spec and "remote" is the
+// endpoint under test.  Frames received from the remote endpoint which
+// are not permitted in the state of their stream are kept as illegal
+// frames.
+type streamTracker struct {
+	mu         sync.Mutex
+	server     bool // true if the local endpoint is the server
+	states     map[uint32]StreamState
+	resetLocal map[uint32]bool // streams closed by a RST_STREAM we sent
+	used       map[uint32]bool // streams on which we sent any frame
+	misused    map[uint32]bool // streams on which we broke the rules
+	lastLocal  uint32          // the highest stream opened by local
+	lastRemote uint32          // the highest stream opened by remote
+	illegal    []*ResultIllegalFrame
+	sent       *frameSplitter
+}
+
+func newStreamTracker(server bool) *streamTracker {
+	st := &streamTracker{
+		server:     server,
+		states:     map[uint32]StreamState{},
+		resetLocal: map[uint32]bool{},
+		used:       map[uint32]bool{},
+		misused:    map[uint32]bool{},
+	}
+	st.sent = &frameSplitter{fn: st.trackSent}
+	return st
+}
+
+// localInitiated returns true if streamID belongs to the streams
+// initiated by the local endpoint.
+func (st *streamTracker) localInitiated(streamID uint32) bool {
+	return (streamID%2 == 1) != st.server
+}
+
+// state returns the state of streamID.  An idle stream is closed
+// implicitly once a higher stream is opened by the same endpoint.
+func (st *streamTracker) state(streamID uint32) StreamState {
+	s := st.states[streamID]
+	if s != StateIdle {
+		return s
+	}
+
+	last := st.lastRemote
+	if st.localInitiated(streamID) {
+		last = st.lastLocal
+	}
+	if streamID <= last {
+		return StateClosed
+	}
+	return StateIdle
+}
+
+// opened records that streamID left the idle state.
+func (st *streamTracker) opened(streamID uint32) {
+	if st.localInitiated(streamID) {
+		if streamID > st.lastLocal {
+			st.lastLocal = streamID
+		}
+	} else if streamID > st.lastRemote {
+		st.lastRemote = streamID
+	}
+}
+
+// Write takes the bytes written to the connection after the connection
+// preface, and tracks the frames sent.
+func (st *streamTracker) Write(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.sent.Write(p)
+}
+
+// trackSent tracks a frame sent to the remote endpoint.
+func (st *streamTracker) trackSent(fh http2.FrameHeader, payload []byte) {
+	streamID := fh.StreamID
+	st.used[streamID] = true
+
+	// test cases send frames which are not permitted on purpose.
+	// The reaction of the endpoint to them is checked by the test
+	// case instead.
+	switch state := st.state(streamID); fh.Type {
+	case http2.FrameHeaders:
+		switch state {
+		case StateIdle, StateReservedLocal, StateOpen, StateHalfClosedRemote:
+		default:
+			st.misused[streamID] = true
+		}
+	case http2.FrameData:
+		if state != StateOpen && state != StateHalfClosedRemote {
+			st.misused[streamID] = true
+		}
+	}
+
+	switch fh.Type {
+	case http2.FrameHeaders:
+		st.headers(streamID, fh.Flags.Has(http2.FlagHeadersEndStream), true)
+	case http2.FrameData:
+		if fh.Flags.Has(http2.FlagDataEndStream) {
+			st.endStream(streamID, true)
+		}
+	case http2.FrameRSTStream:
+		st.states[streamID] = StateClosed
+		st.resetLocal[streamID] = true
+	case http2.FramePushPromise:
+		if fh.Flags.Has(http2.FlagPushPromisePadded) && len(payload) > 0 {
+			payload = payload[1:]
+		}
+		if len(payload) >= 4 {
+			st.promise(binary.BigEndian.Uint32(payload)&(1<<31-1), true)
+		}
+	}
+}
+
+// recv checks the frame received from the remote endpoint against the
+// state of its stream, and then tracks it.
+func (st *streamTracker) recv(f http2.Frame) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	streamID := f.Header().StreamID
+	if streamID == 0 {
+		return
+	}
+
+	state := st.state(streamID)
+	if !st.permitted(f, state) {
+		st.illegal = append(st.illegal, &ResultIllegalFrame{
+			Type:     f.Header().Type,
+			StreamID: streamID,
+			State:    state,
+		})
+		return
+	}
+
+	switch f := f.(type) {
+	case *http2.HeadersFrame:
+		st.headers(streamID, f.StreamEnded(), false)
+	case *http2.DataFrame:
+		if f.StreamEnded() {
+			st.endStream(streamID, false)
+		}
+	case *http2.RSTStreamFrame:
+		st.states[streamID] = StateClosed
+	case *http2.PushPromiseFrame:
+		st.promise(f.PromiseID, false)
+	}
+}
+
+// permitted returns true if the remote endpoint may send f on a stream
+// in state.
+func (st *streamTracker) permitted(f http2.Frame, state StreamState) bool {
+	streamID := f.Header().StreamID
+
+	// frames already in flight when we reset the stream must be
+	// ignored.
+	if state == StateClosed && st.resetLocal[streamID] {
+		return true
+	}
+	if st.misused[streamID] {
+		return true
+	}
+
+	switch f := f.(type) {
+	case *http2.PriorityFrame, *http2.ContinuationFrame, *http2.UnknownFrame:
+		return true
+	case *http2.HeadersFrame:
+		switch state {
+		case StateIdle:
+			// only clients open streams with HEADERS frames.
+			return st.server && !st.localInitiated(streamID)
+		case StateReservedRemote, StateOpen, StateHalfClosedLocal:
+			return true
+		}
+		return false
+	case *http2.DataFrame:
+		return state == StateOpen || state == StateHalfClosedLocal
+	case *http2.RSTStreamFrame:
+		// a frame we sent on an idle stream, like a PRIORITY frame
+		// depending on itself, may be answered with a stream error.
+		return state != StateIdle || st.used[streamID]
+	case *http2.WindowUpdateFrame:
+		return state != StateIdle && state != StateReservedRemote
+	case *http2.PushPromiseFrame:
+		// only servers push, on a stream opened by the client, and
+		// the promised stream must be a new one.
+		if st.server || st.state(f.PromiseID) != StateIdle || st.localInitiated(f.PromiseID) {
+			return false
+		}
+		return state == StateOpen || state == StateHalfClosedLocal
+	}
+
+	// SETTINGS, PING and GOAWAY frames are not allowed on a stream
+	// at all, which the framer reports as an error instead.
+	return true
+}
+
+// headers tracks a HEADERS frame sent by local if local is true, or by
+// remote otherwise.
+func (st *streamTracker) headers(streamID uint32, endStream, local bool) {
+	switch st.state(streamID) {
+	case StateIdle:
+		st.opened(streamID)
+		st.states[streamID] = StateOpen
+	case StateReservedLocal:
+		if local {
+			st.states[streamID] = StateHalfClosedRemote
+		}
+	case StateReservedRemote:
+		if !local {
+			st.states[streamID] = StateHalfClosedLocal
+		}
+	}
+
+	if endStream {
+		st.endStream(streamID, local)
+	}
+}
+
+// endStream tracks the END_STREAM flag sent by local if local is true,
+// or by remote otherwise.
+func (st *streamTracker) endStream(streamID uint32, local bool) {
+	switch st.state(streamID) {
+	case StateOpen:
+		if local {
+			st.states[streamID] = StateHalfClosedLocal
+		} else {
+			st.states[streamID] = StateHalfClosedRemote
+		}
+	case StateHalfClosedLocal:
+		if !local {
+			st.states[streamID] = StateClosed
+		}
+	case StateHalfClosedRemote:
+		if local {
+			st.states[streamID] = StateClosed
+		}
+	}
+}
+
+// promise tracks the stream reserved by a PUSH_PROMISE frame sent by
+// local if local is true, or by remote otherwise.
+func (st *streamTracker) promise(promiseID uint32, local bool) {
+	if st.state(promiseID) != StateIdle {
+		return
+	}
+
+	st.opened(promiseID)
+	if local {
+		st.states[promiseID] = StateReservedLocal
+	} else {
+		st.states[promiseID] = StateReservedRemote
+	}
+}
+
+// StreamState returns the state of streamID as observed from the frames
+// sent and received so far.
+func (h2Conn *Http2Conn) StreamState(streamID uint32) StreamState {
+	h2Conn.streams.mu.Lock()
+	defer h2Conn.streams.mu.Unlock()
+
+	return h2Conn.streams.state(streamID)
+}
+
+// NextStreamID returns the lowest stream identifier which h2spec can
+// use to open a new stream.
+func (h2Conn *Http2Conn) NextStreamID() uint32 {
+	h2Conn.streams.mu.Lock()
+	defer h2Conn.streams.mu.Unlock()
+
+	if h2Conn.streams.lastLocal == 0 {
+		if h2Conn.streams.server {
+			return 2
+		}
+		return 1
+	}
+	return h2Conn.streams.lastLocal + 2
+}
+
+// IllegalFrames returns the frames received so far which the endpoint
+// under test was not permitted to send in the state of their stream.
+func (h2Conn *Http2Conn) IllegalFrames() []*ResultIllegalFrame {
+	h2Conn.streams.mu.Lock()
+	defer h2Conn.streams.mu.Unlock()
+
+	return append([]*ResultIllegalFrame(nil), h2Conn.streams.illegal...)
+}
@@ -0,0 +1,365 @@
+package h2spec
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+var allStreamStates = []StreamState{
+	StateIdle,
+	StateReservedLocal,
+	StateReservedRemote,
+	StateOpen,
+	StateHalfClosedLocal,
+	StateHalfClosedRemote,
+	StateClosed,
+}
+
+// readTestFrame returns the frame written by write.
+func readTestFrame(t *testing.T, write func(fr *http2.Framer) error) http2.Frame {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := write(http2.NewFramer(&buf, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := http2.NewFramer(nil, &buf).ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// trackerIn returns a tracker of a client connection on which streamID
+// is in state.
+func trackerIn(streamID uint32, state StreamState) *streamTracker {
+	st := newStreamTracker(false)
+	if state != StateIdle {
+		st.states[streamID] = state
+	}
+	return st
+}
+
+func TestPermitted(t *testing.T) {
+	const streamID = 3
+
+	frames := []struct {
+		name    string
+		write   func(fr *http2.Framer) error
+		allowed map[StreamState]bool
+	}{
+		{
+			name: "HEADERS",
+			write: func(fr *http2.Framer) error {
+				return fr.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      streamID,
+					BlockFragment: []byte{0x88},
+					EndHeaders:    true,
+				})
+			},
+			allowed: map[StreamState]bool{
+				StateReservedRemote:  true,
+				StateOpen:            true,
+				StateHalfClosedLocal: true,
+			},
+		},
+		{
+			name: "DATA",
+			write: func(fr *http2.Framer) error {
+				return fr.WriteData(streamID, false, []byte("h2spec"))
+			},
+			allowed: map[StreamState]bool{
+				StateOpen:            true,
+				StateHalfClosedLocal: true,
+			},
+		},
+		{
+			name: "RST_STREAM",
+			write: func(fr *http2.Framer) error {
+				return fr.WriteRSTStream(streamID, http2.ErrCodeCancel)
+			},
+			allowed: map[StreamState]bool{
+				StateReservedLocal:    true,
+				StateReservedRemote:   true,
+				StateOpen:             true,
+				StateHalfClosedLocal:  true,
+				StateHalfClosedRemote: true,
+				StateClosed:           true,
+			},
+		},
+		{
+			name: "WINDOW_UPDATE",
+			write: func(fr *http2.Framer) error {
+				return fr.WriteWindowUpdate(streamID, 1)
+			},
+			allowed: map[StreamState]bool{
+				StateReservedLocal:    true,
+				StateOpen:             true,
+				StateHalfClosedLocal:  true,
+				StateHalfClosedRemote: true,
+				StateClosed:           true,
+			},
+		},
+		{
+			name: "PRIORITY",
+			write: func(fr *http2.Framer) error {
+				return fr.WritePriority(streamID, http2.PriorityParam{Weight: 15})
+			},
+			allowed: map[StreamState]bool{
+				StateIdle:             true,
+				StateReservedLocal:    true,
+				StateReservedRemote:   true,
+				StateOpen:             true,
+				StateHalfClosedLocal:  true,
+				StateHalfClosedRemote: true,
+				StateClosed:           true,
+			},
+		},
+	}
+
+	for _, tt := range frames {
+		f := readTestFrame(t, tt.write)
+		for _, state := range allStreamStates {
+			st := trackerIn(streamID, state)
+			got := st.permitted(f, st.state(streamID))
+			if got != tt.allowed[state] {
+				t.Errorf("%s in %s: permitted = %v, want %v", tt.name, state, got, tt.allowed[state])
+			}
+		}
+	}
+}
+
+func TestPermittedExceptions(t *testing.T) {
+	data := readTestFrame(t, func(fr *http2.Framer) error {
+		return fr.WriteData(1, false, []byte("h2spec"))
+	})
+
+	// DATA frames in flight when we reset the stream.
+	st := trackerIn(1, StateClosed)
+	st.resetLocal[1] = true
+	if !st.permitted(data, st.state(1)) {
+		t.Errorf("DATA on a stream reset by us: not permitted")
+	}
+
+	// any reaction to a stream on which we broke the rules.
+	st = trackerIn(1, StateIdle)
+	st.misused[1] = true
+	if !st.permitted(data, st.state(1)) {
+		t.Errorf("DATA on a misused stream: not permitted")
+	}
+
+	// a RST_STREAM frame answering a frame sent on an idle stream.
+	rst := readTestFrame(t, func(fr *http2.Framer) error {
+		return fr.WriteRSTStream(1, http2.ErrCodeProtocol)
+	})
+	st = trackerIn(1, StateIdle)
+	st.used[1] = true
+	if !st.permitted(rst, st.state(1)) {
+		t.Errorf("RST_STREAM on a used idle stream: not permitted")
+	}
+
+	// clients open streams with HEADERS frames, servers do not.
+	headers := readTestFrame(t, func(fr *http2.Framer) error {
+		return fr.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      1,
+			BlockFragment: []byte{0x82},
+			EndHeaders:    true,
+		})
+	})
+	st = newStreamTracker(true)
+	if !st.permitted(headers, st.state(1)) {
+		t.Errorf("HEADERS opening a stream of the client: not permitted")
+	}
+	st = newStreamTracker(false)
+	if st.permitted(headers, st.state(1)) {
+		t.Errorf("HEADERS opening a stream of the server on stream 1: permitted")
+	}
+}
+
+func TestPermittedPushPromise(t *testing.T) {
+	promise := func(promiseID uint32) http2.Frame {
+		return readTestFrame(t, func(fr *http2.Framer) error {
+			return fr.WritePushPromise(http2.PushPromiseParam{
+				StreamID:      1,
+				PromiseID:     promiseID,
+				BlockFragment: []byte{0x82},
+				EndHeaders:    true,
+			})
+		})
+	}
+
+	tests := []struct {
+		name      string
+		server    bool
+		state     StreamState
+		promiseID uint32
+		want      bool
+	}{
+		{"on an open stream", false, StateOpen, 2, true},
+		{"on a half-closed (local) stream", false, StateHalfClosedLocal, 2, true},
+		{"on a half-closed (remote) stream", false, StateHalfClosedRemote, 2, false},
+		{"on a closed stream", false, StateClosed, 2, false},
+		{"promising a stream of the client", false, StateOpen, 3, false},
+		{"sent by a client", true, StateOpen, 2, false},
+	}
+
+	for _, tt := range tests {
+		st := newStreamTracker(tt.server)
+		st.states[1] = tt.state
+		got := st.permitted(promise(tt.promiseID), st.state(1))
+		if got != tt.want {
+			t.Errorf("PUSH_PROMISE %s: permitted = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the promised stream must be idle.
+	st := newStreamTracker(false)
+	st.states[1] = StateOpen
+	st.states[2] = StateReservedRemote
+	if st.permitted(promise(2), st.state(1)) {
+		t.Errorf("PUSH_PROMISE promising a reserved stream: permitted")
+	}
+}
+
+func TestTrackSent(t *testing.T) {
+	const streamID = 3
+
+	type outcome struct {
+		state   StreamState
+		misused bool
+	}
+
+	frames := []struct {
+		name string
+		fh   http2.FrameHeader
+		want map[StreamState]outcome
+	}{
+		{
+			name: "HEADERS",
+			fh:   http2.FrameHeader{Type: http2.FrameHeaders, Flags: http2.FlagHeadersEndHeaders},
+			want: map[StreamState]outcome{
+				StateIdle:             {StateOpen, false},
+				StateReservedLocal:    {StateHalfClosedRemote, false},
+				StateReservedRemote:   {StateReservedRemote, true},
+				StateOpen:             {StateOpen, false},
+				StateHalfClosedLocal:  {StateHalfClosedLocal, true},
+				StateHalfClosedRemote: {StateHalfClosedRemote, false},
+				StateClosed:           {StateClosed, true},
+			},
+		},
+		{
+			name: "HEADERS with END_STREAM",
+			fh:   http2.FrameHeader{Type: http2.FrameHeaders, Flags: http2.FlagHeadersEndHeaders | http2.FlagHeadersEndStream},
+			want: map[StreamState]outcome{
+				StateIdle:             {StateHalfClosedLocal, false},
+				StateReservedLocal:    {StateClosed, false},
+				StateReservedRemote:   {StateReservedRemote, true},
+				StateOpen:             {StateHalfClosedLocal, false},
+				StateHalfClosedLocal:  {StateHalfClosedLocal, true},
+				StateHalfClosedRemote: {StateClosed, false},
+				StateClosed:           {StateClosed, true},
+			},
+		},
+		{
+			name: "DATA",
+			fh:   http2.FrameHeader{Type: http2.FrameData},
+			want: map[StreamState]outcome{
+				StateIdle:             {StateIdle, true},
+				StateReservedLocal:    {StateReservedLocal, true},
+				StateReservedRemote:   {StateReservedRemote, true},
+				StateOpen:             {StateOpen, false},
+				StateHalfClosedLocal:  {StateHalfClosedLocal, true},
+				StateHalfClosedRemote: {StateHalfClosedRemote, false},
+				StateClosed:           {StateClosed, true},
+			},
+		},
+		{
+			name: "DATA with END_STREAM",
+			fh:   http2.FrameHeader{Type: http2.FrameData, Flags: http2.FlagDataEndStream},
+			want: map[StreamState]outcome{
+				StateIdle:             {StateIdle, true},
+				StateReservedLocal:    {StateReservedLocal, true},
+				StateReservedRemote:   {StateReservedRemote, true},
+				StateOpen:             {StateHalfClosedLocal, false},
+				StateHalfClosedLocal:  {StateHalfClosedLocal, true},
+				StateHalfClosedRemote: {StateClosed, false},
+				StateClosed:           {StateClosed, true},
+			},
+		},
+		{
+			name: "RST_STREAM",
+			fh:   http2.FrameHeader{Type: http2.FrameRSTStream},
+			want: map[StreamState]outcome{
+				StateIdle:             {StateClosed, false},
+				StateReservedLocal:    {StateClosed, false},
+				StateReservedRemote:   {StateClosed, false},
+				StateOpen:             {StateClosed, false},
+				StateHalfClosedLocal:  {StateClosed, false},
+				StateHalfClosedRemote: {StateClosed, false},
+				StateClosed:           {StateClosed, false},
+			},
+		},
+	}
+
+	for _, tt := range frames {
+		for _, state := range allStreamStates {
+			st := trackerIn(streamID, state)
+			fh := tt.fh
+			fh.StreamID = streamID
+			st.trackSent(fh, nil)
+
+			got := outcome{st.state(streamID), st.misused[streamID]}
+			if got != tt.want[state] {
+				t.Errorf("%s in %s: got %s (misused %v), want %s (misused %v)",
+					tt.name, state, got.state, got.misused, tt.want[state].state, tt.want[state].misused)
+			}
+			if !st.used[streamID] {
+				t.Errorf("%s in %s: stream not marked as used", tt.name, state)
+			}
+		}
+	}
+}
+
+func TestTrackSentRstStream(t *testing.T) {
+	st := trackerIn(1, StateOpen)
+	st.trackSent(http2.FrameHeader{Type: http2.FrameRSTStream, StreamID: 1}, nil)
+	if !st.resetLocal[1] {
+		t.Errorf("stream reset by us not recorded")
+	}
+}
+
+func TestTrackSentPushPromise(t *testing.T) {
+	st := newStreamTracker(true)
+	st.states[1] = StateOpen
+
+	payload := []byte{0, 0, 0, 2, 0x82}
+	st.trackSent(http2.FrameHeader{Type: http2.FramePushPromise, Flags: http2.FlagPushPromiseEndHeaders, StreamID: 1}, payload)
+	if got := st.state(2); got != StateReservedLocal {
+		t.Errorf("promised stream: got %s, want %s", got, StateReservedLocal)
+	}
+
+	padded := []byte{1, 0, 0, 0, 4, 0x82, 0}
+	st.trackSent(http2.FrameHeader{Type: http2.FramePushPromise, Flags: http2.FlagPushPromiseEndHeaders | http2.FlagPushPromisePadded, StreamID: 1}, padded)
+	if got := st.state(4); got != StateReservedLocal {
+		t.Errorf("promised stream of a padded frame: got %s, want %s", got, StateReservedLocal)
+	}
+}
+
+func TestImplicitClose(t *testing.T) {
+	st := newStreamTracker(false)
+	st.trackSent(http2.FrameHeader{Type: http2.FrameHeaders, Flags: http2.FlagHeadersEndHeaders, StreamID: 5}, nil)
+
+	if got := st.state(3); got != StateClosed {
+		t.Errorf("idle stream below a stream opened: got %s, want %s", got, StateClosed)
+	}
+	if got := st.state(2); got != StateIdle {
+		t.Errorf("idle stream of the peer: got %s, want %s", got, StateIdle)
+	}
+	if got := st.state(7); got != StateIdle {
+		t.Errorf("idle stream above a stream opened: got %s, want %s", got, StateIdle)
+	}
+}
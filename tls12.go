@@ -0,0 +1,595 @@
+package h2spec
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"time"
+)
+
+// TLS record types.
+const (
+	tlsRecordChangeCipherSpec = 20
+	tlsRecordAlert            = 21
+	tlsRecordHandshake        = 22
+	tlsRecordApplicationData  = 23
+)
+
+// TLS handshake message types.
+const (
+	tlsHelloRequest       = 0
+	tlsServerHello        = 2
+	tlsCertificate        = 11
+	tlsServerKeyExchange  = 12
+	tlsCertificateRequest = 13
+	tlsServerHelloDone    = 14
+	tlsCertificateVerify  = 15
+	tlsClientKeyExchange  = 16
+	tlsFinished           = 20
+)
+
+// tls12CipherSuites are the cipher suites implemented by tls12Conn.
+// HTTP/2 endpoints support TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 at
+// least (RFC 7540, 9.2.2).
+var tls12CipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+// errRenegotiationAccepted is returned by tls12Conn.Read when the
+// endpoint answers a renegotiation with a ServerHello.
+var errRenegotiationAccepted = errors.New("The endpoint accepted the TLS renegotiation")
+
+// tls12Conn is a minimal TLS 1.2 client connection.  crypto/tls cannot
+// start a renegotiation, so the test case of renegotiation runs its own
+// handshake with ECDHE and AES-128-GCM.  The certificate of the server
+// is not verified, since no request is sent on the connection.
+type tls12Conn struct {
+	net.Conn
+	config       *tls.Config
+	transcript   hash.Hash // the handshake messages so far
+	clientRandom []byte
+	serverRandom []byte
+	ems          bool // the extended master secret is used
+	masterSecret []byte
+	clientVerify []byte // verify_data of the Finished message sent
+	protocol     string // the protocol selected with ALPN
+	in, out      *tls12Cipher
+	nextIn       *tls12Cipher // enabled by ChangeCipherSpec
+	handshake    []byte       // handshake messages not read yet
+	input        []byte       // application data not read yet
+}
+
+// tls12Cipher protects the records sent in one direction with
+// AES-128-GCM.
+type tls12Cipher struct {
+	aead cipher.AEAD
+	iv   []byte
+	seq  uint64
+}
+
+func newTls12Cipher(key, iv []byte) (*tls12Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tls12Cipher{aead: aead, iv: iv}, nil
+}
+
+func (tc *tls12Cipher) additionalData(typ byte, n int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, tc.seq)
+	ad[8] = typ
+	ad[9], ad[10] = 0x03, 0x03
+	binary.BigEndian.PutUint16(ad[11:], uint16(n))
+	return ad
+}
+
+func (tc *tls12Cipher) seal(typ byte, data []byte) []byte {
+	explicit := make([]byte, 8)
+	binary.BigEndian.PutUint64(explicit, tc.seq)
+	nonce := append(append([]byte(nil), tc.iv...), explicit...)
+
+	sealed := tc.aead.Seal(explicit, nonce, data, tc.additionalData(typ, len(data)))
+	tc.seq++
+	return sealed
+}
+
+func (tc *tls12Cipher) open(typ byte, data []byte) ([]byte, error) {
+	if len(data) < 8+tc.aead.Overhead() {
+		return nil, fmt.Errorf("Malformed TLS record")
+	}
+	nonce := append(append([]byte(nil), tc.iv...), data[:8]...)
+	n := len(data) - 8 - tc.aead.Overhead()
+
+	plain, err := tc.aead.Open(nil, nonce, data[8:], tc.additionalData(typ, n))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt a TLS record (%v)", err)
+	}
+	tc.seq++
+	return plain, nil
+}
+
+// dialTls12 connects to the target server and completes a TLS 1.2
+// handshake with tls12Conn.
+func dialTls12(ctx *Context) (*tls12Conn, error) {
+	conn, err := net.DialTimeout("tcp", ctx.Authority(), ctx.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.testCase != nil {
+		ctx.testCase.addPort(conn.LocalAddr())
+	}
+
+	c := &tls12Conn{
+		Conn:       conn,
+		config:     tlsConfig(ctx),
+		transcript: sha256.New(),
+	}
+
+	conn.SetDeadline(time.Now().Add(ctx.Timeout))
+	err = c.clientHandshake(tlsServerName(ctx))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return c, nil
+}
+
+// clientHandshake runs a full TLS 1.2 handshake.
+func (c *tls12Conn) clientHandshake(serverName string) error {
+	c.clientRandom = make([]byte, 32)
+	rand.Read(c.clientRandom)
+
+	err := c.writeHandshake(clientHelloMessage(serverName, c.clientRandom, tls12CipherSuites, []byte{0}, nil))
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	err = c.readServerHello(msg)
+	if err != nil {
+		return err
+	}
+
+	var curve ecdh.Curve
+	var serverKey []byte
+	certRequested := false
+	for done := false; !done; {
+		msg, err := c.readHandshake()
+		if err != nil {
+			return err
+		}
+
+		body := msg[4:]
+		switch msg[0] {
+		case tlsCertificate:
+		case tlsServerKeyExchange:
+			curve, serverKey, err = parseServerKeyExchange(body)
+			if err != nil {
+				return err
+			}
+		case tlsCertificateRequest:
+			certRequested = true
+		case tlsServerHelloDone:
+			done = true
+		default:
+			return fmt.Errorf("Unexpected TLS handshake message of type %d", msg[0])
+		}
+	}
+	if curve == nil {
+		return fmt.Errorf("ServerKeyExchange is missing")
+	}
+
+	key, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	peerKey, err := curve.NewPublicKey(serverKey)
+	if err != nil {
+		return fmt.Errorf("Malformed ServerKeyExchange (%v)", err)
+	}
+	preMasterSecret, err := key.ECDH(peerKey)
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if certRequested {
+		if len(c.config.Certificates) > 0 {
+			cert = &c.config.Certificates[0]
+		}
+		err = c.writeHandshake(certificateMessage(cert))
+		if err != nil {
+			return err
+		}
+	}
+
+	pub := key.PublicKey().Bytes()
+	cke := append([]byte{tlsClientKeyExchange, 0, 0, byte(len(pub) + 1), byte(len(pub))}, pub...)
+	err = c.writeHandshake(cke)
+	if err != nil {
+		return err
+	}
+
+	if c.ems {
+		c.masterSecret = prf12(preMasterSecret, "extended master secret", c.transcript.Sum(nil), 48)
+	} else {
+		seed := append(append([]byte(nil), c.clientRandom...), c.serverRandom...)
+		c.masterSecret = prf12(preMasterSecret, "master secret", seed, 48)
+	}
+	if w := c.config.KeyLogWriter; w != nil {
+		fmt.Fprintf(w, "CLIENT_RANDOM %x %x\n", c.clientRandom, c.masterSecret)
+	}
+
+	if cert != nil {
+		msg, err := certificateVerifyMessage(cert, c.transcript.Sum(nil))
+		if err != nil {
+			return err
+		}
+		err = c.writeHandshake(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	seed := append(append([]byte(nil), c.serverRandom...), c.clientRandom...)
+	keys := prf12(c.masterSecret, "key expansion", seed, 40)
+	out, err := newTls12Cipher(keys[0:16], keys[32:36])
+	if err != nil {
+		return err
+	}
+	c.nextIn, err = newTls12Cipher(keys[16:32], keys[36:40])
+	if err != nil {
+		return err
+	}
+
+	err = c.writeRecord(tlsRecordChangeCipherSpec, []byte{1})
+	if err != nil {
+		return err
+	}
+	c.out = out
+
+	c.clientVerify = prf12(c.masterSecret, "client finished", c.transcript.Sum(nil), 12)
+	err = c.writeHandshake(append([]byte{tlsFinished, 0, 0, 12}, c.clientVerify...))
+	if err != nil {
+		return err
+	}
+
+	serverVerify := prf12(c.masterSecret, "server finished", c.transcript.Sum(nil), 12)
+	msg, err = c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if c.in == nil || msg[0] != tlsFinished || !hmac.Equal(msg[4:], serverVerify) {
+		return fmt.Errorf("The Finished message of the server does not verify")
+	}
+
+	return nil
+}
+
+// readServerHello reads the ServerHello handshake message msg.
+func (c *tls12Conn) readServerHello(msg []byte) error {
+	body := msg[4:]
+	// version, random and session ID.
+	if msg[0] != tlsServerHello || len(body) < 35 {
+		return fmt.Errorf("Malformed ServerHello")
+	}
+	if body[0] != 0x03 || body[1] != 0x03 {
+		return fmt.Errorf("The endpoint selected TLS version %#04x instead of TLS 1.2", binary.BigEndian.Uint16(body))
+	}
+	c.serverRandom = append([]byte(nil), body[2:34]...)
+
+	offset := 35 + int(body[34])
+	// cipher suite and compression method.
+	if len(body) < offset+3 {
+		return fmt.Errorf("Malformed ServerHello")
+	}
+	suite := binary.BigEndian.Uint16(body[offset:])
+	if suite != tls12CipherSuites[0] && suite != tls12CipherSuites[1] {
+		return fmt.Errorf("The endpoint selected cipher suite %#04x which was not offered", suite)
+	}
+	if body[offset+2] != 0 {
+		return fmt.Errorf("The endpoint selected compression method %d which was not offered", body[offset+2])
+	}
+
+	exts := body[offset+3:]
+	if len(exts) >= 2 {
+		exts = exts[2:]
+	}
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts)
+		n := int(binary.BigEndian.Uint16(exts[2:]))
+		if len(exts) < 4+n {
+			return fmt.Errorf("Malformed ServerHello")
+		}
+		data := exts[4 : 4+n]
+		exts = exts[4+n:]
+
+		switch typ {
+		case 0x0010: // application_layer_protocol_negotiation
+			if len(data) >= 3 && len(data) >= 3+int(data[2]) {
+				c.protocol = string(data[3 : 3+int(data[2])])
+			}
+		case 0x0017: // extended_master_secret
+			c.ems = true
+		}
+	}
+
+	return nil
+}
+
+// parseServerKeyExchange returns the named curve and the public key of
+// an ECDHE ServerKeyExchange message.  The signature is not verified.
+func parseServerKeyExchange(body []byte) (ecdh.Curve, []byte, error) {
+	if len(body) < 4 || body[0] != 3 || len(body) < 4+int(body[3]) {
+		return nil, nil, fmt.Errorf("Malformed ServerKeyExchange")
+	}
+
+	var curve ecdh.Curve
+	switch id := binary.BigEndian.Uint16(body[1:]); id {
+	case 0x001d:
+		curve = ecdh.X25519()
+	case 0x0017:
+		curve = ecdh.P256()
+	case 0x0018:
+		curve = ecdh.P384()
+	default:
+		return nil, nil, fmt.Errorf("The endpoint selected curve %#04x which was not offered", id)
+	}
+
+	return curve, body[4 : 4+int(body[3])], nil
+}
+
+// certificateMessage returns a Certificate handshake message carrying
+// the chain of cert, or no certificate if cert is nil.
+func certificateMessage(cert *tls.Certificate) []byte {
+	var certs []byte
+	if cert != nil {
+		for _, der := range cert.Certificate {
+			certs = append(certs, byte(len(der)>>16), byte(len(der)>>8), byte(len(der)))
+			certs = append(certs, der...)
+		}
+	}
+
+	body := []byte{byte(len(certs) >> 16), byte(len(certs) >> 8), byte(len(certs))}
+	body = append(body, certs...)
+
+	msg := []byte{tlsCertificate, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(msg, body...)
+}
+
+// certificateVerifyMessage returns a CertificateVerify handshake
+// message signing the transcript hash with the private key of cert.
+func certificateVerifyMessage(cert *tls.Certificate, transcript []byte) ([]byte, error) {
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("The private key of the certificate cannot sign")
+	}
+
+	var alg uint16
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		alg = 0x0401 // rsa_pkcs1_sha256
+	case *ecdsa.PublicKey:
+		alg = 0x0403 // ecdsa_secp256r1_sha256
+	default:
+		return nil, fmt.Errorf("The private key of the certificate is not supported with TLS 1.2")
+	}
+
+	sig, err := signer.Sign(rand.Reader, transcript, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	body := appendUint16(nil, alg)
+	body = appendUint16(body, uint16(len(sig)), sig...)
+
+	msg := []byte{tlsCertificateVerify, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(msg, body...), nil
+}
+
+// prf12 is the pseudorandom function of TLS 1.2 with SHA-256 (RFC 5246,
+// 5).
+func prf12(secret []byte, label string, seed []byte, n int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	mac := hmac.New(sha256.New, secret)
+
+	mac.Write(labelSeed)
+	a := mac.Sum(nil)
+
+	var out []byte
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
+
+// renegotiate sends a ClientHello on the established connection.  The
+// reaction of the endpoint is returned by the next Read calls.
+func (c *tls12Conn) renegotiate(serverName string) error {
+	random := make([]byte, 32)
+	rand.Read(random)
+
+	msg := clientHelloMessage(serverName, random, tls12CipherSuites, []byte{0}, c.clientVerify)
+	return c.writeRecord(tlsRecordHandshake, msg)
+}
+
+// readRecord reads a record and decrypts it once the ChangeCipherSpec
+// of the server was received.
+func (c *tls12Conn) readRecord() (byte, []byte, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(c.Conn, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	_, err = io.ReadFull(c.Conn, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	typ := header[0]
+	if c.in != nil {
+		data, err = c.in.open(typ, data)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return typ, data, nil
+}
+
+// writeRecord writes data as records of type typ, encrypted once the
+// ChangeCipherSpec was sent.
+func (c *tls12Conn) writeRecord(typ byte, data []byte) error {
+	for {
+		n := len(data)
+		if n > 16384 {
+			n = 16384
+		}
+
+		fragment := data[:n]
+		if c.out != nil {
+			fragment = c.out.seal(typ, fragment)
+		}
+		record := []byte{typ, 0x03, 0x03}
+		record = appendUint16(record, uint16(len(fragment)), fragment...)
+
+		_, err := c.Conn.Write(record)
+		if err != nil {
+			return err
+		}
+
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// writeHandshake writes the handshake message msg and adds it to the
+// transcript.
+func (c *tls12Conn) writeHandshake(msg []byte) error {
+	c.transcript.Write(msg)
+	return c.writeRecord(tlsRecordHandshake, msg)
+}
+
+// readHandshake reads a handshake message and adds it to the
+// transcript.  A ChangeCipherSpec record on the way enables the
+// decryption of the next records.
+func (c *tls12Conn) readHandshake() ([]byte, error) {
+	for {
+		if len(c.handshake) >= 4 {
+			n := 4 + (int(c.handshake[1])<<16 | int(c.handshake[2])<<8 | int(c.handshake[3]))
+			if len(c.handshake) >= n {
+				msg := append([]byte(nil), c.handshake[:n]...)
+				c.handshake = c.handshake[n:]
+				c.transcript.Write(msg)
+				return msg, nil
+			}
+		}
+
+		typ, data, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case tlsRecordHandshake:
+			c.handshake = append(c.handshake, data...)
+		case tlsRecordChangeCipherSpec:
+			if c.nextIn == nil {
+				return nil, fmt.Errorf("Unexpected ChangeCipherSpec")
+			}
+			c.in, c.nextIn = c.nextIn, nil
+		case tlsRecordAlert:
+			return nil, alertError(data)
+		default:
+			return nil, fmt.Errorf("Unexpected TLS record of type %d during the handshake", typ)
+		}
+	}
+}
+
+// alertError returns the error for the alert record data.  A
+// close_notify alert ends the connection like io.EOF.
+func alertError(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("Malformed TLS alert")
+	}
+	if data[1] == 0 {
+		return io.EOF
+	}
+	return tlsAlert(data[1])
+}
+
+// Read reads application data.  A ServerHello returns
+// errRenegotiationAccepted, and an alert returns a tlsAlert error.
+func (c *tls12Conn) Read(p []byte) (int, error) {
+	for len(c.input) == 0 {
+		typ, data, err := c.readRecord()
+		if err != nil {
+			return 0, err
+		}
+
+		switch typ {
+		case tlsRecordApplicationData:
+			c.input = data
+		case tlsRecordAlert:
+			return 0, alertError(data)
+		case tlsRecordHandshake:
+			if len(data) == 0 {
+				return 0, fmt.Errorf("Malformed TLS handshake message")
+			}
+			if data[0] == tlsServerHello {
+				return 0, errRenegotiationAccepted
+			}
+			if data[0] != tlsHelloRequest {
+				return 0, fmt.Errorf("Unexpected TLS handshake message of type %d", data[0])
+			}
+		default:
+			return 0, fmt.Errorf("Unexpected TLS record of type %d", typ)
+		}
+	}
+
+	n := copy(p, c.input)
+	c.input = c.input[n:]
+	return n, nil
+}
+
+// Write writes p as application data.
+func (c *tls12Conn) Write(p []byte) (int, error) {
+	err := c.writeRecord(tlsRecordApplicationData, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
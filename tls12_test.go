@@ -0,0 +1,140 @@
+package h2spec
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPrf12(t *testing.T) {
+	secret := mustHex(t, "9bbe436ba940f017b17652849a71db35")
+	seed := mustHex(t, "a0ba9f936cda311827a6f796ffd5198c")
+	want := "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a" +
+		"6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab" +
+		"4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff701" +
+		"87347b66"
+
+	got := prf12(secret, "test label", seed, 100)
+	if hex.EncodeToString(got) != want {
+		t.Errorf("got %x, want %s", got, want)
+	}
+}
+
+// serveTls12 accepts a single TLS connection on a local port with
+// crypto/tls, and runs serve on it after the handshake.
+func serveTls12(t *testing.T, serve func(conn *tls.Conn)) *Context {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "h2spec.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"h2"},
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tlsConn := conn.(*tls.Conn)
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		serve(tlsConn)
+	}()
+
+	return &Context{
+		Host:    "127.0.0.1",
+		Port:    ln.Addr().(*net.TCPAddr).Port,
+		Tls:     true,
+		Timeout: time.Second,
+	}
+}
+
+func TestTls12Conn(t *testing.T) {
+	ctx := serveTls12(t, func(conn *tls.Conn) {
+		io.Copy(conn, conn)
+	})
+
+	conn, err := dialTls12(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.protocol != "h2" {
+		t.Errorf("protocol: got %q, want \"h2\"", conn.protocol)
+	}
+
+	// more than a record each way.
+	data := bytes.Repeat([]byte("h2spec"), 5000)
+	_, err = conn.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+	_, err = io.ReadFull(conn, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Errorf("application data not echoed")
+	}
+}
+
+func TestTls12ConnRenegotiationRefused(t *testing.T) {
+	ctx := serveTls12(t, func(conn *tls.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+
+	conn, err := dialTls12(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.renegotiate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+	_, err = conn.Read(make([]byte, 1))
+	var alert tlsAlert
+	if !errors.As(err, &alert) {
+		t.Errorf("got %v, want a TLS alert", err)
+	}
+}
@@ -0,0 +1,371 @@
+package h2spec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Trace records the frames exchanged with the target server during a
+// test case.
+type Trace struct {
+	mu       sync.Mutex
+	lines    []string
+	numConns int
+}
+
+// Logf appends a timestamped line to the trace.
+func (t *Trace) Logf(format string, a ...interface{}) {
+	line := time.Now().Format("15:04:05.000000") + " " + fmt.Sprintf(format, a...)
+
+	t.mu.Lock()
+	t.lines = append(t.lines, line)
+	t.mu.Unlock()
+}
+
+// Lines returns the lines recorded so far.
+func (t *Trace) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := make([]string, len(t.lines))
+	copy(lines, t.lines)
+	return lines
+}
+
+// WriteTo writes the recorded lines to w.
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, line := range t.Lines() {
+		m, err := fmt.Fprintln(w, line)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// traceConn returns conn wrapped so that every frame written to or
+// read from it is logged to the trace.  If http1 is true, the frames
+// are expected to follow an HTTP/1.1 Upgrade request and response.
+func (t *Trace) traceConn(conn net.Conn, http1 bool) net.Conn {
+	id := t.nextConnID()
+	t.Logf("[%d] connected %s -> %s", id, conn.LocalAddr(), conn.RemoteAddr())
+
+	send := &frameTap{trace: t, label: fmt.Sprintf("[%d] send", id), preface: []byte(clientPreface), http1: http1}
+	recv := &frameTap{trace: t, label: fmt.Sprintf("[%d] recv", id), http1: http1}
+
+	return t.tap(conn, id, send, recv)
+}
+
+// traceServerConn is like traceConn but for a connection accepted from
+// a client, which sends the connection preface.
+func (t *Trace) traceServerConn(conn net.Conn) net.Conn {
+	id := t.nextConnID()
+	t.Logf("[%d] accepted %s -> %s", id, conn.RemoteAddr(), conn.LocalAddr())
+
+	send := &frameTap{trace: t, label: fmt.Sprintf("[%d] send", id)}
+	recv := &frameTap{trace: t, label: fmt.Sprintf("[%d] recv", id), preface: []byte(clientPreface)}
+
+	return t.tap(conn, id, send, recv)
+}
+
+func (t *Trace) nextConnID() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.numConns++
+	return t.numConns
+}
+
+func (t *Trace) tap(conn net.Conn, id int, send, recv *frameTap) net.Conn {
+	readErr := func(err error) {
+		t.Logf("[%d] recv error: %v", id, err)
+	}
+
+	return &tapConn{Conn: conn, in: recv, out: send, readErr: readErr}
+}
+
+// tapConn copies all bytes read from and written to the underlying
+// connection to in and out respectively.
+type tapConn struct {
+	net.Conn
+	in      io.Writer
+	out     io.Writer
+	readErr func(error) // called when reading fails, if not nil
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.in.Write(p[:n])
+	}
+	if err != nil && c.readErr != nil {
+		c.readErr(err)
+	}
+	return n, err
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.out.Write(p[:n])
+	}
+	return n, err
+}
+
+// frameTap splits a byte stream into HTTP/2 frames and logs a summary
+// of each frame to the trace.  Bytes which are not HTTP/2 framing are
+// logged as they are.
+type frameTap struct {
+	trace   *Trace
+	label   string
+	preface []byte // the part of the connection preface not seen yet
+	http1   bool   // true while an HTTP/1.1 message header is expected
+	raw     bool   // true if the stream turned out not to be HTTP/2
+	buf     []byte
+	decoder *hpack.Decoder
+	fields  []string
+}
+
+func (ft *frameTap) Write(p []byte) (int, error) {
+	np := len(p)
+
+	if ft.raw {
+		ft.logRaw(p)
+		return np, nil
+	}
+
+	if ft.http1 {
+		ft.buf = append(ft.buf, p...)
+		end := bytes.Index(ft.buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			return np, nil
+		}
+
+		lines := strings.Split(string(ft.buf[:end]), "\r\n")
+		for _, line := range lines {
+			ft.trace.Logf("%s %s", ft.label, line)
+		}
+		p = ft.buf[end+4:]
+		ft.buf = nil
+		ft.http1 = false
+
+		// a response other than 101 is not followed by HTTP/2
+		// frames.
+		if strings.HasPrefix(lines[0], "HTTP/") && !strings.Contains(lines[0], " 101 ") {
+			ft.raw = true
+			if len(p) > 0 {
+				ft.logRaw(p)
+			}
+			return np, nil
+		}
+	}
+
+	if len(ft.preface) > 0 {
+		n := len(p)
+		if n > len(ft.preface) {
+			n = len(ft.preface)
+		}
+		if !bytes.Equal(p[:n], ft.preface[:n]) {
+			ft.raw = true
+			ft.logRaw(p)
+			return np, nil
+		}
+		ft.preface = ft.preface[n:]
+		if len(ft.preface) == 0 {
+			ft.trace.Logf("%s connection preface", ft.label)
+		}
+		p = p[n:]
+	}
+
+	ft.buf = append(ft.buf, p...)
+	for len(ft.buf) >= 9 {
+		length := int(ft.buf[0])<<16 | int(ft.buf[1])<<8 | int(ft.buf[2])
+		if len(ft.buf) < 9+length {
+			break
+		}
+		ft.logFrame(ft.buf[:9+length])
+		ft.buf = ft.buf[9+length:]
+	}
+
+	return np, nil
+}
+
+func (ft *frameTap) logRaw(p []byte) {
+	const max = 64
+	if len(p) > max {
+		ft.trace.Logf("%s %d bytes: %q...", ft.label, len(p), p[:max])
+	} else {
+		ft.trace.Logf("%s %d bytes: %q", ft.label, len(p), p)
+	}
+}
+
+func (ft *frameTap) logFrame(data []byte) {
+	fh := http2.FrameHeader{
+		Type:     http2.FrameType(data[3]),
+		Flags:    http2.Flags(data[4]),
+		Length:   uint32(len(data) - 9),
+		StreamID: binary.BigEndian.Uint32(data[5:9]) & (1<<31 - 1),
+	}
+
+	header := fmt.Sprintf("%s %s frame <length=%d, flags=%s, stream_id=%d>",
+		ft.label, fh.Type, fh.Length, flagNames(fh.Type, fh.Flags), fh.StreamID)
+
+	// CONTINUATION frames cannot be parsed without the preceding
+	// HEADERS frame, so they are handled here.
+	if fh.Type == http2.FrameContinuation {
+		ft.trace.Logf("%s", header)
+		ft.decodeHeaderBlock(data[9:], fh.Flags.Has(http2.FlagContinuationEndHeaders))
+		return
+	}
+
+	fr := http2.NewFramer(nil, bytes.NewReader(data))
+	fr.SetMaxReadFrameSize(1<<24 - 1)
+	f, err := fr.ReadFrame()
+	if err != nil {
+		ft.trace.Logf("%s (malformed: %v)", header, err)
+		return
+	}
+
+	detail := ""
+	switch f := f.(type) {
+	case *http2.DataFrame:
+		detail = fmt.Sprintf("data_length=%d", len(f.Data()))
+	case *http2.HeadersFrame:
+		if f.HasPriority() {
+			detail = priorityDetail(f.Priority)
+		}
+		ft.logDetail(header, detail)
+		ft.decodeHeaderBlock(f.HeaderBlockFragment(), f.HeadersEnded())
+		return
+	case *http2.PriorityFrame:
+		detail = priorityDetail(f.PriorityParam)
+	case *http2.RSTStreamFrame:
+		detail = fmt.Sprintf("error_code=%s", f.ErrCode)
+	case *http2.SettingsFrame:
+		settings := []string{}
+		f.ForeachSetting(func(s http2.Setting) error {
+			settings = append(settings, fmt.Sprintf("%s=%d", s.ID, s.Val))
+			return nil
+		})
+		detail = strings.Join(settings, ", ")
+	case *http2.PushPromiseFrame:
+		detail = fmt.Sprintf("promised_stream_id=%d", f.PromiseID)
+		ft.logDetail(header, detail)
+		ft.decodeHeaderBlock(f.HeaderBlockFragment(), f.HeadersEnded())
+		return
+	case *http2.PingFrame:
+		detail = fmt.Sprintf("opaque_data=%x", f.Data)
+	case *http2.GoAwayFrame:
+		detail = fmt.Sprintf("last_stream_id=%d, error_code=%s", f.LastStreamID, f.ErrCode)
+		if len(f.DebugData()) > 0 {
+			detail += fmt.Sprintf(", debug_data=%q", f.DebugData())
+		}
+	case *http2.WindowUpdateFrame:
+		detail = fmt.Sprintf("window_size_increment=%d", f.Increment)
+	}
+
+	ft.logDetail(header, detail)
+}
+
+func (ft *frameTap) logDetail(header, detail string) {
+	if detail == "" {
+		ft.trace.Logf("%s", header)
+	} else {
+		ft.trace.Logf("%s %s", header, detail)
+	}
+}
+
+// decodeHeaderBlock decodes a header block fragment with the decoding
+// context of this direction and logs the header fields once the block
+// is complete.
+func (ft *frameTap) decodeHeaderBlock(fragment []byte, end bool) {
+	if ft.decoder == nil {
+		ft.decoder = hpack.NewDecoder(4096, func(hf hpack.HeaderField) {
+			ft.fields = append(ft.fields, hf.String())
+		})
+	}
+
+	_, err := ft.decoder.Write(fragment)
+	if err == nil && end {
+		err = ft.decoder.Close()
+	}
+	if err != nil {
+		ft.trace.Logf("%s   (header block decoding error: %v)", ft.label, err)
+		ft.fields = nil
+		return
+	}
+
+	if end {
+		for _, field := range ft.fields {
+			ft.trace.Logf("%s   %s", ft.label, field)
+		}
+		ft.fields = nil
+	}
+}
+
+func priorityDetail(p http2.PriorityParam) string {
+	return fmt.Sprintf("stream_dependency=%d, weight=%d, exclusive=%t", p.StreamDep, int(p.Weight)+1, p.Exclusive)
+}
+
+var flagNameTable = map[http2.FrameType][]struct {
+	flag http2.Flags
+	name string
+}{
+	http2.FrameData: {
+		{http2.FlagDataEndStream, "END_STREAM"},
+		{http2.FlagDataPadded, "PADDED"},
+	},
+	http2.FrameHeaders: {
+		{http2.FlagHeadersEndStream, "END_STREAM"},
+		{http2.FlagHeadersEndHeaders, "END_HEADERS"},
+		{http2.FlagHeadersPadded, "PADDED"},
+		{http2.FlagHeadersPriority, "PRIORITY"},
+	},
+	http2.FrameSettings: {
+		{http2.FlagSettingsAck, "ACK"},
+	},
+	http2.FramePing: {
+		{http2.FlagPingAck, "ACK"},
+	},
+	http2.FramePushPromise: {
+		{http2.FlagPushPromiseEndHeaders, "END_HEADERS"},
+		{http2.FlagPushPromisePadded, "PADDED"},
+	},
+	http2.FrameContinuation: {
+		{http2.FlagContinuationEndHeaders, "END_HEADERS"},
+	},
+}
+
+// flagNames returns the names of the flags set on a frame of type t.
+func flagNames(t http2.FrameType, flags http2.Flags) string {
+	if flags == 0 {
+		return "0x0"
+	}
+
+	names := []string{}
+	rest := flags
+	for _, f := range flagNameTable[t] {
+		if flags.Has(f.flag) {
+			names = append(names, f.name)
+			rest &^= f.flag
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint8(rest)))
+	}
+
+	return strings.Join(names, "|")
+}
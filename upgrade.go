@@ -0,0 +1,106 @@
+package h2spec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// upgradeRequestHeaders returns the header fields of a valid HTTP/1.1
+// request upgrading the connection to h2c (RFC 7540, 3.2).  The
+// HTTP2-Settings header field is empty since the client connection
+// preface carries the SETTINGS frame.
+func upgradeRequestHeaders(ctx *Context) []string {
+	return []string{
+		"Host: " + commonHeaderFieldAuthority(ctx).Value,
+		"Connection: Upgrade, HTTP2-Settings",
+		"Upgrade: h2c",
+		"HTTP2-Settings: ",
+	}
+}
+
+// writeUpgradeRequest writes a GET request for "/" with the given
+// header lines to w.
+func writeUpgradeRequest(w io.Writer, headers []string) error {
+	req := "GET / HTTP/1.1\r\n" + strings.Join(headers, "\r\n") + "\r\n\r\n"
+	_, err := io.WriteString(w, req)
+	return err
+}
+
+// readUpgradeResponse reads the response header of an HTTP/1.1 request
+// from br, waiting at most ctx.Timeout.
+func readUpgradeResponse(ctx *Context, conn net.Conn, br *bufio.Reader) (*http.Response, error) {
+	conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		if opErr, ok := err.(net.Error); ok && opErr.Timeout() {
+			return nil, TIMEOUT
+		}
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// isUpgraded returns true if res switches the protocol to h2c.
+func isUpgraded(res *http.Response) bool {
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return false
+	}
+	if !strings.EqualFold(res.Header.Get("Upgrade"), "h2c") {
+		return false
+	}
+	return hasToken(res.Header.Get("Connection"), "upgrade")
+}
+
+// hasToken returns true if the comma separated list v contains token,
+// compared case-insensitively.
+func hasToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgrade performs the HTTP/1.1 Upgrade handshake on conn and returns
+// the reader from which the HTTP/2 frames sent by the server must be
+// read.
+func upgrade(ctx *Context, conn net.Conn) (*bufio.Reader, error) {
+	err := writeUpgradeRequest(conn, upgradeRequestHeaders(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := readUpgradeResponse(ctx, conn, br)
+	if err != nil {
+		return nil, fmt.Errorf("h2c upgrade failed (%v)", err)
+	}
+	res.Body.Close()
+
+	if !isUpgraded(res) {
+		return nil, fmt.Errorf("h2c upgrade failed (%s)", res.Status)
+	}
+
+	return br, nil
+}
+
+// bufferedConn is a connection whose reads are served by r, which
+// holds the bytes the server sent after the response to the upgrade
+// request.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}